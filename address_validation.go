@@ -0,0 +1,114 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// defaultAddressValidationThreshold is the default number of unvalidated handshakes,
+// above which clients have to validate their address.
+const defaultAddressValidationThreshold = 100
+
+// defaultHandshakeTimeout is quic-go's default handshake timeout.
+const defaultHandshakeTimeout = 10 * time.Second
+
+const (
+	// tokenValidity is the time that tokens sent in a NEW_TOKEN frame are valid for.
+	tokenValidity = 24 * time.Hour
+	// retryTokenValidity is the time that tokens sent in a Retry packet are valid for.
+	retryTokenValidity = 10 * time.Second
+)
+
+// AddressValidation is the decision of an AddressValidationPolicy.
+type AddressValidation uint8
+
+const (
+	// DefaultAddressValidation applies the built-in policy, see WithAddressValidationThreshold.
+	DefaultAddressValidation AddressValidation = iota
+	// RequireAddressValidation requires the client to validate its address, unless it presented a valid token.
+	RequireAddressValidation
+	// SkipAddressValidation accepts the handshake without validating the client's address.
+	SkipAddressValidation
+)
+
+// An AddressValidationPolicy decides if a client has to validate its address before the handshake.
+// It is called with the client's address, and the token presented by the client.
+// The token is nil if the client didn't present a token, or if the token couldn't be decrypted.
+// Tokens are only passed to the policy after quic-go verified that it issued them,
+// but they might have expired, or have been issued to a different address.
+type AddressValidationPolicy func(clientAddr net.Addr, token *quic.Token) AddressValidation
+
+// The addressValidator requires clients to validate their address using a Retry,
+// if too many handshakes from unvalidated addresses are in progress.
+// Handshakes from unvalidated addresses can be used for amplification attacks,
+// since the server's first flight is a lot larger than the client's Initial.
+type addressValidator struct {
+	policy    AddressValidationPolicy
+	threshold int
+	// window is the time a handshake is considered to be in progress.
+	window time.Duration
+
+	mutex sync.Mutex
+	// starts are the start times of the unvalidated handshakes, ordered by time
+	starts []time.Time
+}
+
+func newAddressValidator(policy AddressValidationPolicy, threshold int, window time.Duration) *addressValidator {
+	return &addressValidator{policy: policy, threshold: threshold, window: window}
+}
+
+// AcceptToken is used as the AcceptToken callback of the quic.Config.
+// If it returns false, quic-go sends a Retry.
+func (v *addressValidator) AcceptToken(clientAddr net.Addr, token *quic.Token) bool {
+	if v.policy != nil {
+		switch v.policy(clientAddr, token) {
+		case RequireAddressValidation:
+			return isValidToken(clientAddr, token)
+		case SkipAddressValidation:
+			return true
+		}
+	}
+	if isValidToken(clientAddr, token) {
+		return true
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	now := time.Now()
+	var expired int
+	for _, start := range v.starts {
+		if now.Sub(start) < v.window {
+			break
+		}
+		expired++
+	}
+	v.starts = v.starts[expired:]
+	if len(v.starts) >= v.threshold {
+		return false
+	}
+	v.starts = append(v.starts, now)
+	return true
+}
+
+// isValidToken checks that a token was issued to the client's IP address, and hasn't expired yet.
+// quic-go only passes tokens to AcceptToken that it issued itself.
+func isValidToken(clientAddr net.Addr, token *quic.Token) bool {
+	if token == nil {
+		return false
+	}
+	validity := tokenValidity
+	if token.IsRetryToken {
+		validity = retryTokenValidity
+	}
+	if time.Since(token.SentTime) > validity {
+		return false
+	}
+	if udpAddr, ok := clientAddr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String() == token.RemoteAddr
+	}
+	return clientAddr.String() == token.RemoteAddr
+}
@@ -0,0 +1,94 @@
+package libp2pquic
+
+import (
+	"net"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Address Validation", func() {
+	clientAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Port: 1337}
+
+	It("accepts handshakes from unvalidated addresses up to the threshold", func() {
+		v := newAddressValidator(nil, 2, time.Hour)
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeTrue())
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeTrue())
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeFalse())
+	})
+
+	It("accepts handshakes from unvalidated addresses again after the window", func() {
+		v := newAddressValidator(nil, 1, 50*time.Millisecond)
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeTrue())
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeFalse())
+		Eventually(func() bool { return v.AcceptToken(clientAddr, nil) }).Should(BeTrue())
+	})
+
+	It("accepts valid tokens above the threshold", func() {
+		v := newAddressValidator(nil, 0, time.Hour)
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeFalse())
+		Expect(v.AcceptToken(clientAddr, &quic.Token{
+			IsRetryToken: true,
+			RemoteAddr:   "192.168.0.42",
+			SentTime:     time.Now(),
+		})).To(BeTrue())
+		Expect(v.AcceptToken(clientAddr, &quic.Token{
+			RemoteAddr: "192.168.0.42",
+			SentTime:   time.Now().Add(-time.Hour),
+		})).To(BeTrue())
+	})
+
+	It("rejects tokens for a different address", func() {
+		v := newAddressValidator(nil, 0, time.Hour)
+		Expect(v.AcceptToken(clientAddr, &quic.Token{
+			IsRetryToken: true,
+			RemoteAddr:   "192.168.0.43",
+			SentTime:     time.Now(),
+		})).To(BeFalse())
+	})
+
+	It("rejects expired tokens", func() {
+		v := newAddressValidator(nil, 0, time.Hour)
+		Expect(v.AcceptToken(clientAddr, &quic.Token{
+			IsRetryToken: true,
+			RemoteAddr:   "192.168.0.42",
+			SentTime:     time.Now().Add(-retryTokenValidity - time.Second),
+		})).To(BeFalse())
+		Expect(v.AcceptToken(clientAddr, &quic.Token{
+			RemoteAddr: "192.168.0.42",
+			SentTime:   time.Now().Add(-tokenValidity - time.Second),
+		})).To(BeFalse())
+	})
+
+	It("applies the policy", func() {
+		_, private, err := net.ParseCIDR("192.168.0.0/24")
+		Expect(err).ToNot(HaveOccurred())
+		var tokens []*quic.Token
+		policy := func(addr net.Addr, token *quic.Token) AddressValidation {
+			tokens = append(tokens, token)
+			if private.Contains(addr.(*net.UDPAddr).IP) {
+				return RequireAddressValidation
+			}
+			if addr.(*net.UDPAddr).IP.IsLoopback() {
+				return SkipAddressValidation
+			}
+			return DefaultAddressValidation
+		}
+		v := newAddressValidator(policy, 1, time.Hour)
+		Expect(v.AcceptToken(clientAddr, nil)).To(BeFalse())
+		token := &quic.Token{IsRetryToken: true, RemoteAddr: "192.168.0.42", SentTime: time.Now()}
+		Expect(v.AcceptToken(clientAddr, token)).To(BeTrue())
+		Expect(tokens).To(Equal([]*quic.Token{nil, token}))
+		// skipping validation doesn't count towards the threshold
+		loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
+		Expect(v.AcceptToken(loopback, nil)).To(BeTrue())
+		Expect(v.AcceptToken(loopback, nil)).To(BeTrue())
+		// the default policy applies to all other addresses
+		other := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1337}
+		Expect(v.AcceptToken(other, nil)).To(BeTrue())
+		Expect(v.AcceptToken(other, nil)).To(BeFalse())
+	})
+})
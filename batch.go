@@ -0,0 +1,112 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchReader reads multiple packets at once.
+// It is implemented by ipv4.PacketConn and ipv6.PacketConn, which use recvmmsg on Linux.
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchConn receives packets in batches, and returns them one by one from ReadFrom.
+// quic-go reads every socket from a single goroutine, so this saves the syscalls for all but the first packet of a batch.
+// Packets are sent one by one, quic-go v0.12 doesn't send multiple packets at once.
+type batchConn struct {
+	*net.UDPConn
+	reader batchReader
+
+	// dstAddrs records the destination addresses of the received packets. It is nil if they're not recorded.
+	dstAddrs *dstAddrs
+	// parseDst parses the destination address from the control message of a packet
+	parseDst func(oob []byte) net.IP
+
+	mutex sync.Mutex
+	msgs  []ipv4.Message
+	// pending are the packets that were received, but not yet returned by ReadFrom
+	pending []ipv4.Message
+}
+
+func newBatchConn(network string, conn *net.UDPConn, size int) *batchConn {
+	c := &batchConn{
+		UDPConn: conn,
+		msgs:    make([]ipv4.Message, size),
+	}
+	if network == "udp6" {
+		c.reader = ipv6.NewPacketConn(conn)
+	} else {
+		c.reader = ipv4.NewPacketConn(conn)
+	}
+	return c
+}
+
+// recordDstAddrs records the destination address of every received packet.
+// The destination addresses are only known on platforms that support IP_PKTINFO or IP_RECVDSTADDR,
+// on other platforms, nothing is recorded.
+func (c *batchConn) recordDstAddrs(dstAddrs *dstAddrs) {
+	var oobSize int
+	switch reader := c.reader.(type) {
+	case *ipv4.PacketConn:
+		if err := reader.SetControlMessage(ipv4.FlagDst, true); err != nil {
+			return
+		}
+		oobSize = len(ipv4.NewControlMessage(ipv4.FlagDst))
+		c.parseDst = func(oob []byte) net.IP {
+			var cm ipv4.ControlMessage
+			if err := cm.Parse(oob); err != nil {
+				return nil
+			}
+			return cm.Dst
+		}
+	case *ipv6.PacketConn:
+		if err := reader.SetControlMessage(ipv6.FlagDst, true); err != nil {
+			return
+		}
+		oobSize = len(ipv6.NewControlMessage(ipv6.FlagDst))
+		c.parseDst = func(oob []byte) net.IP {
+			var cm ipv6.ControlMessage
+			if err := cm.Parse(oob); err != nil {
+				return nil
+			}
+			return cm.Dst
+		}
+	}
+	if oobSize == 0 {
+		return
+	}
+	for i := range c.msgs {
+		c.msgs[i].OOB = make([]byte, oobSize)
+	}
+	c.dstAddrs = dstAddrs
+}
+
+func (c *batchConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.pending) == 0 {
+		// the buffers are allocated with the size that quic-go uses for all reads
+		for i := range c.msgs {
+			if len(c.msgs[i].Buffers) == 0 || len(c.msgs[i].Buffers[0]) < len(b) {
+				c.msgs[i].Buffers = [][]byte{make([]byte, len(b))}
+			}
+		}
+		n, err := c.reader.ReadBatch(c.msgs, 0)
+		if err != nil {
+			return 0, nil, err
+		}
+		c.pending = c.msgs[:n]
+	}
+	msg := c.pending[0]
+	c.pending = c.pending[1:]
+	if c.dstAddrs != nil {
+		if dst := c.parseDst(msg.OOB[:msg.NN]); dst != nil {
+			c.dstAddrs.record(msg.Addr, dst)
+		}
+	}
+	return copy(b, msg.Buffers[0][:msg.N]), msg.Addr, nil
+}
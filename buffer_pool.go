@@ -0,0 +1,38 @@
+package libp2pquic
+
+import "sync"
+
+// maxPacketSize is the size of the largest packet sent or received on a socket,
+// including the overhead added in a private network.
+const maxPacketSize = 1500
+
+// packetBufferPool holds the buffers used to process packets.
+// It is shared by all sockets, so that steady-state traffic doesn't allocate.
+var packetBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maxPacketSize)
+		return &b
+	},
+}
+
+// getPacketBuffer returns a buffer of at least size bytes.
+// Buffers larger than maxPacketSize are allocated, and not returned to the pool.
+func getPacketBuffer(size int) *[]byte {
+	if size > maxPacketSize {
+		b := make([]byte, size)
+		return &b
+	}
+	b := packetBufferPool.Get().(*[]byte)
+	*b = (*b)[:size]
+	return b
+}
+
+// putPacketBuffer returns a buffer to the pool.
+// The buffer must not be used afterwards.
+func putPacketBuffer(b *[]byte) {
+	if cap(*b) != maxPacketSize {
+		return
+	}
+	*b = (*b)[:maxPacketSize]
+	packetBufferPool.Put(b)
+}
@@ -2,19 +2,126 @@ package libp2pquic
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
 
 	quic "github.com/lucas-clemente/quic-go"
 	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/time/rate"
 )
 
+var (
+	// ErrConnClosing is returned by OpenStream and AcceptStream when the connection is being closed.
+	ErrConnClosing = errors.New("connection is closing")
+	// ErrConnClosed is returned by OpenStream and AcceptStream when the connection is closed.
+	ErrConnClosed = errors.New("connection closed")
+)
+
+const (
+	// ErrorCodeMaxLifetimeExceeded is the application error code used to close connections
+	// that exceeded the maximum lifetime set by WithMaxConnectionLifetime.
+	ErrorCodeMaxLifetimeExceeded quic.ErrorCode = 1
+	// ErrorCodeDuplicateConnection is the application error code used to close connections
+	// according to the DuplicateConnectionPolicy.
+	ErrorCodeDuplicateConnection quic.ErrorCode = 2
+	// ErrorCodeNoActivity is the application error code used to close inbound connections
+	// that didn't use any streams within the deadline set by WithInboundActivityDeadline.
+	ErrorCodeNoActivity quic.ErrorCode = 3
+	// ErrorCodeGated is the application error code used to close connections
+	// that were rejected by the ConnectionGater after the handshake.
+	ErrorCodeGated quic.ErrorCode = 4
+	// ErrorCodeResourceLimitExceeded is the application error code used to close connections
+	// that were refused by the ResourceManager.
+	ErrorCodeResourceLimitExceeded quic.ErrorCode = 5
+	// ErrorCodeConnLimitExceeded is the application error code used to close accepted connections
+	// above the limit set by WithMaxInboundConns. Usually, these connections are already refused during the handshake.
+	ErrorCodeConnLimitExceeded quic.ErrorCode = 6
+)
+
+// EstablishmentType describes how a connection was established.
+type EstablishmentType uint8
+
+const (
+	// EstablishmentFullHandshake means that the connection was established using a full 1-RTT handshake.
+	EstablishmentFullHandshake EstablishmentType = iota
+	// EstablishmentResumed means that the connection was established by resuming a previous TLS session.
+	EstablishmentResumed
+)
+
+func (t EstablishmentType) String() string {
+	switch t {
+	case EstablishmentFullHandshake:
+		return "full handshake"
+	case EstablishmentResumed:
+		return "resumed"
+	default:
+		return fmt.Sprintf("unknown establishment type: %d", t)
+	}
+}
+
+// Conn is a QUIC connection.
+// It is returned by Dial and Accept, and extends the tpt.CapableConn with QUIC specific functionality.
+type Conn interface {
+	tpt.CapableConn
+
+	// SetOutboundStreamRateLimit limits the rate at which OpenStream opens new streams.
+	// OpenStream blocks when the limit is exceeded, until a token becomes available or the connection is closed.
+	// burst must be at least 1, unless limit is rate.Inf.
+	// By default, the rate of new streams is not limited.
+	SetOutboundStreamRateLimit(limit rate.Limit, burst int)
+	// EstablishmentType returns how the connection was established.
+	// Connections are only returned after the handshake completed, so the value never changes.
+	// Note that quic-go doesn't support 0-RTT yet, so there's no establishment type for 0-RTT connections.
+	EstablishmentType() EstablishmentType
+	// Streams returns a point-in-time snapshot of the state of all streams of this connection, sorted by stream ID.
+	// Streams are removed once they are closed in both directions, or reset.
+	Streams() []StreamState
+	// HandshakeTimings returns the durations of the phases of the handshake.
+	// Timings are only recorded when dialing, for accepted connections all durations are 0.
+	HandshakeTimings() HandshakeTimings
+	// Stats returns a point-in-time snapshot of the statistics of the connection.
+	// quic-go doesn't expose its internal state (RTT, congestion window, packet loss and retransmissions),
+	// so only the statistics gathered by the transport are available.
+	Stats() ConnStatsSnapshot
+	// OpenUniStream opens a new unidirectional stream, blocking until the peer allows the stream to be opened.
+	// Peers only allow unidirectional streams if they enabled them using WithMaxUniStreams,
+	// otherwise OpenUniStream blocks until the context is cancelled.
+	OpenUniStream(context.Context) (SendStream, error)
+	// AcceptUniStream accepts a unidirectional stream opened by the other side.
+	// Unidirectional streams are only accepted if they were enabled using WithMaxUniStreams.
+	AcceptUniStream(context.Context) (ReceiveStream, error)
+	// Version returns the QUIC version of the connection.
+	// quic-go doesn't expose the version negotiated for a connection. Instead, the most preferred version
+	// configured using WithVersions is returned. This is the negotiated version, as long as quic-go only supports a single version.
+	Version() quic.VersionNumber
+}
+
 type conn struct {
+	stats connStats // must be the first field, see connStats
+
 	sess      quic.Session
 	transport tpt.Transport
+	// closeCtx is cancelled when Close is called, or when the session is closed
+	closeCtx    context.Context
+	closeCancel context.CancelFunc
+
+	mutex         sync.Mutex
+	streamLimiter *rate.Limiter
+	streams       map[*stream]struct{}
+
+	// scope accounts for the resources of the connection. It is nil if no ResourceManager is configured.
+	scope        ConnectionScope
+	streamMemory int
 
 	localPeer      peer.ID
 	privKey        ic.PrivKey
@@ -23,14 +130,60 @@ type conn struct {
 	remotePeerID    peer.ID
 	remotePubKey    ic.PubKey
 	remoteMultiaddr ma.Multiaddr
+
+	direction network.Direction
+	version   quic.VersionNumber
+	opened    time.Time
+	timings   HandshakeTimings
 }
 
-var _ tpt.CapableConn = &conn{}
+var _ Conn = &conn{}
 
 func (c *conn) Close() error {
+	c.closeCancel()
 	return c.sess.Close()
 }
 
+// closeAfterLifetime closes the connection once it has been open for longer than lifetime.
+func (c *conn) closeAfterLifetime(lifetime time.Duration) {
+	timer := time.AfterFunc(time.Until(c.opened.Add(lifetime)), func() {
+		c.closeCancel()
+		c.sess.CloseWithError(ErrorCodeMaxLifetimeExceeded, "maximum connection lifetime exceeded")
+	})
+	go func() {
+		<-c.sess.Context().Done()
+		timer.Stop()
+	}()
+}
+
+// closeIfInactive closes the connection if no stream was opened or accepted within the deadline.
+func (c *conn) closeIfInactive(deadline time.Duration) {
+	timer := time.AfterFunc(time.Until(c.opened.Add(deadline)), func() {
+		if atomic.LoadUint64(&c.stats.streamsOpened) > 0 || atomic.LoadUint64(&c.stats.streamsAccepted) > 0 {
+			return
+		}
+		c.closeCancel()
+		c.sess.CloseWithError(ErrorCodeNoActivity, "no activity")
+	})
+	go func() {
+		<-c.sess.Context().Done()
+		timer.Stop()
+	}()
+}
+
+// initiator returns the peer that initiated the connection.
+func (c *conn) initiator() peer.ID {
+	if c.direction == network.DirOutbound {
+		return c.localPeer
+	}
+	return c.remotePeerID
+}
+
+func (c *conn) closeDuplicate() {
+	c.closeCancel()
+	c.sess.CloseWithError(ErrorCodeDuplicateConnection, "duplicate connection")
+}
+
 // IsClosed returns whether a connection is fully closed.
 func (c *conn) IsClosed() bool {
 	return c.sess.Context().Err() != nil
@@ -38,14 +191,115 @@ func (c *conn) IsClosed() bool {
 
 // OpenStream creates a new stream.
 func (c *conn) OpenStream() (mux.MuxedStream, error) {
-	qstr, err := c.sess.OpenStreamSync(context.Background())
-	return &stream{Stream: qstr}, err
+	c.mutex.Lock()
+	limiter := c.streamLimiter
+	c.mutex.Unlock()
+	if limiter != nil {
+		if err := limiter.Wait(c.closeCtx); err != nil {
+			return nil, c.closeError(err)
+		}
+	}
+	scope, err := c.openStreamScope(network.DirOutbound)
+	if err != nil {
+		return nil, err
+	}
+	qstr, err := c.sess.OpenStreamSync(c.closeCtx)
+	if err != nil {
+		if scope != nil {
+			scope.Done()
+		}
+		return nil, c.closeError(err)
+	}
+	atomic.AddUint64(&c.stats.streamsOpened, 1)
+	return newStream(qstr, c, network.DirOutbound, scope), nil
+}
+
+// SetOutboundStreamRateLimit limits the rate at which new streams are opened.
+func (c *conn) SetOutboundStreamRateLimit(limit rate.Limit, burst int) {
+	c.mutex.Lock()
+	c.streamLimiter = rate.NewLimiter(limit, burst)
+	c.mutex.Unlock()
 }
 
 // AcceptStream accepts a stream opened by the other side.
 func (c *conn) AcceptStream() (mux.MuxedStream, error) {
-	qstr, err := c.sess.AcceptStream(context.Background())
-	return &stream{Stream: qstr}, err
+	for {
+		qstr, err := c.sess.AcceptStream(c.closeCtx)
+		if err != nil {
+			return nil, c.closeError(err)
+		}
+		scope, err := c.openStreamScope(network.DirInbound)
+		if err != nil {
+			// reset the stream, and accept the next one
+			qstr.CancelRead(0)
+			qstr.CancelWrite(0)
+			continue
+		}
+		atomic.AddUint64(&c.stats.streamsAccepted, 1)
+		return newStream(qstr, c, network.DirInbound, scope), nil
+	}
+}
+
+// Streams returns the state of all open streams.
+func (c *conn) Streams() []StreamState {
+	c.mutex.Lock()
+	streams := make([]*stream, 0, len(c.streams))
+	for str := range c.streams {
+		streams = append(streams, str)
+	}
+	c.mutex.Unlock()
+
+	states := make([]StreamState, 0, len(streams))
+	for _, str := range streams {
+		states = append(states, str.state())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states
+}
+
+func (c *conn) addStream(str *stream) {
+	c.mutex.Lock()
+	if c.streams == nil {
+		c.streams = make(map[*stream]struct{})
+	}
+	c.streams[str] = struct{}{}
+	c.mutex.Unlock()
+}
+
+func (c *conn) removeStream(str *stream) {
+	c.mutex.Lock()
+	delete(c.streams, str)
+	c.mutex.Unlock()
+}
+
+// closeError converts an error that occurred while opening or accepting a stream
+// into ErrConnClosing or ErrConnClosed, if it was caused by closing the connection.
+func (c *conn) closeError(err error) error {
+	if c.sess.Context().Err() != nil {
+		return ErrConnClosed
+	}
+	if c.closeCtx.Err() != nil {
+		return ErrConnClosing
+	}
+	return err
+}
+
+// EstablishmentType returns how the connection was established.
+func (c *conn) EstablishmentType() EstablishmentType {
+	if c.sess.ConnectionState().DidResume {
+		return EstablishmentResumed
+	}
+	return EstablishmentFullHandshake
+}
+
+// Version returns the QUIC version of the connection, the first configured version.
+func (c *conn) Version() quic.VersionNumber {
+	return c.version
+}
+
+// HandshakeTimings returns the durations of the phases of the handshake.
+func (c *conn) HandshakeTimings() HandshakeTimings {
+	return c.timings
 }
 
 // LocalPeer returns our peer ID
@@ -0,0 +1,121 @@
+package libp2pquic
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type newConnectionKey struct{}
+
+// WithNewConnection returns a context that makes dials establish a new connection,
+// even if connection reuse is enabled using WithConnectionReuse.
+// The new connection isn't reused by later dials.
+func WithNewConnection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, newConnectionKey{}, true)
+}
+
+func newConnectionFromContext(ctx context.Context) bool {
+	newConn, _ := ctx.Value(newConnectionKey{}).(bool)
+	return newConn
+}
+
+// The connCache keeps the connections established by dials, so they can be returned by later dials
+// of the same peer and addresses. Concurrent dials of the same peer and addresses are merged.
+type connCache struct {
+	mutex sync.Mutex
+	conns map[string]*conn
+	dials map[string]*cachedDial
+}
+
+// A cachedDial is a dial that is in progress. Concurrent dials wait for it to complete.
+type cachedDial struct {
+	done chan struct{}
+	conn tpt.CapableConn
+	err  error
+}
+
+func newConnCache() *connCache {
+	return &connCache{
+		conns: make(map[string]*conn),
+		dials: make(map[string]*cachedDial),
+	}
+}
+
+func connCacheKey(p peer.ID, raddrs []ma.Multiaddr) string {
+	addrs := make([]string, len(raddrs))
+	for i, addr := range raddrs {
+		addrs[i] = addr.String()
+	}
+	return string(p) + " " + strings.Join(addrs, ",")
+}
+
+// dial returns the open connection established by an earlier dial of the peer and addresses.
+// If there is none, it waits for a dial in progress, or dials a new connection.
+func (c *connCache) dial(ctx context.Context, p peer.ID, raddrs []ma.Multiaddr, dial func() (tpt.CapableConn, error)) (tpt.CapableConn, error) {
+	key := connCacheKey(p, raddrs)
+	for {
+		c.mutex.Lock()
+		if existing, ok := c.conns[key]; ok && !existing.IsClosed() {
+			c.mutex.Unlock()
+			return existing, nil
+		}
+		d, ok := c.dials[key]
+		if !ok {
+			break
+		}
+		c.mutex.Unlock()
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// If the dial failed because its context was done, dial again using ours.
+		if d.err == nil || (d.err != context.Canceled && d.err != context.DeadlineExceeded) {
+			return d.conn, d.err
+		}
+	}
+	d := &cachedDial{done: make(chan struct{})}
+	c.dials[key] = d
+	c.mutex.Unlock()
+
+	d.conn, d.err = dial()
+	c.mutex.Lock()
+	delete(c.dials, key)
+	if d.err == nil {
+		c.add(key, d.conn.(*conn))
+	}
+	c.mutex.Unlock()
+	close(d.done)
+	return d.conn, d.err
+}
+
+// add adds a connection to the cache. It is removed as soon as it is closed.
+// The mutex must be held.
+func (c *connCache) add(key string, conn *conn) {
+	c.conns[key] = conn
+	go func() {
+		<-conn.sess.Context().Done()
+		c.mutex.Lock()
+		if c.conns[key] == conn {
+			delete(c.conns, key)
+		}
+		c.mutex.Unlock()
+	}()
+}
+
+// dialCached dials the peer, reusing an existing connection if connection reuse is enabled.
+func (t *transport) dialCached(ctx context.Context, raddrs []ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	isHolePunch, _ := holePunchFromContext(ctx)
+	if t.config.connCache == nil || isHolePunch || newConnectionFromContext(ctx) {
+		return t.dialAndReport(ctx, raddrs, p)
+	}
+	return t.config.connCache.dial(ctx, p, raddrs, func() (tpt.CapableConn, error) {
+		return t.dialAndReport(ctx, raddrs, p)
+	})
+}
@@ -0,0 +1,48 @@
+package libp2pquic
+
+import "errors"
+
+var errInboundConnLimitExceeded = errors.New("inbound connection limit exceeded")
+
+// inboundConnLimitReached says if the listener has as many open inbound connections as allowed by WithMaxInboundConns.
+func (l *listener) inboundConnLimitReached() bool {
+	if l.config.maxInboundConns == 0 {
+		return false
+	}
+	l.inboundMutex.Lock()
+	defer l.inboundMutex.Unlock()
+	return l.inboundConns >= l.config.maxInboundConns
+}
+
+// trackInboundConn counts an accepted connection until it is closed.
+// If the listener already has as many open inbound connections as allowed, errInboundConnLimitExceeded is returned.
+// This happens if multiple handshakes complete at the same time, after passing inboundConnLimitReached.
+func (l *listener) trackInboundConn(c *conn) error {
+	max := l.config.maxInboundConns
+	if max == 0 {
+		return nil
+	}
+	l.inboundMutex.Lock()
+	if l.inboundConns >= max {
+		l.inboundMutex.Unlock()
+		return errInboundConnLimitExceeded
+	}
+	l.inboundConns++
+	if l.inboundConns >= l.config.inboundConnsHighWatermark && !l.aboveHighWatermark {
+		l.aboveHighWatermark = true
+		l.config.warnw("inbound connections reached the high watermark", "addr", l.localMultiaddr, "conns", l.inboundConns, "max", max)
+	}
+	l.inboundMutex.Unlock()
+
+	go func() {
+		<-c.sess.Context().Done()
+		l.inboundMutex.Lock()
+		l.inboundConns--
+		if l.inboundConns < l.config.inboundConnsHighWatermark && l.aboveHighWatermark {
+			l.aboveHighWatermark = false
+			l.config.infow("inbound connections dropped below the high watermark", "addr", l.localMultiaddr, "conns", l.inboundConns, "max", max)
+		}
+		l.inboundMutex.Unlock()
+	}()
+	return nil
+}
@@ -0,0 +1,86 @@
+package libp2pquic
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrDuplicateConnection is returned by Dial when the new connection was closed
+// according to the DuplicateConnectionPolicy, because there already is a connection to the peer.
+var ErrDuplicateConnection = errors.New("duplicate connection")
+
+// A DuplicateConnectionPolicy decides what happens when there are multiple connections to the same peer.
+type DuplicateConnectionPolicy uint8
+
+const (
+	// KeepAllConnections keeps all connections to a peer.
+	KeepAllConnections DuplicateConnectionPolicy = iota
+	// KeepLowerInitiator resolves simultaneous connects, following the libp2p convention:
+	// Of two connections initiated by different peers, the one initiated by the peer with the
+	// lexicographically lower peer ID is kept, and the other one is closed.
+	// Both peers come to the same decision, without any coordination.
+	// Multiple connections initiated by the same peer are all kept.
+	KeepLowerInitiator
+)
+
+// The connRegistry keeps track of all open connections of a transport.
+type connRegistry struct {
+	duplicatePolicy DuplicateConnectionPolicy
+
+	mutex sync.Mutex
+	conns map[*conn]struct{}
+}
+
+// add adds a connection to the registry.
+// The connection is removed as soon as it is closed.
+// If the connection is a duplicate that is rejected by the DuplicateConnectionPolicy,
+// it is closed, and ErrDuplicateConnection is returned.
+func (r *connRegistry) add(c *conn) error {
+	r.mutex.Lock()
+	if r.conns == nil {
+		r.conns = make(map[*conn]struct{})
+	}
+	var losers []*conn
+	if r.duplicatePolicy == KeepLowerInitiator {
+		for existing := range r.conns {
+			if existing.remotePeerID != c.remotePeerID || existing.IsClosed() {
+				continue
+			}
+			existingInitiator, initiator := existing.initiator(), c.initiator()
+			if existingInitiator == initiator {
+				continue
+			}
+			if existingInitiator < initiator {
+				r.mutex.Unlock()
+				c.closeDuplicate()
+				return ErrDuplicateConnection
+			}
+			losers = append(losers, existing)
+		}
+	}
+	r.conns[c] = struct{}{}
+	r.mutex.Unlock()
+
+	for _, loser := range losers {
+		loser.closeDuplicate()
+	}
+
+	go func() {
+		<-c.sess.Context().Done()
+		r.mutex.Lock()
+		delete(r.conns, c)
+		r.mutex.Unlock()
+	}()
+	return nil
+}
+
+// all returns all connections in the registry.
+func (r *connRegistry) all() []*conn {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	conns := make([]*conn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
@@ -5,20 +5,182 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"os"
+	"runtime/trace"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
+	quic "github.com/lucas-clemente/quic-go"
 	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/time/rate"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+type countingPacketConn struct {
+	net.PacketConn
+	read, written *int32
+}
+
+func (c *countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if c.read != nil && err == nil {
+		atomic.AddInt32(c.read, 1)
+	}
+	return n, addr, err
+}
+
+type recordingSessionCache struct {
+	tls.ClientSessionCache
+
+	mutex sync.Mutex
+	keys  []string
+}
+
+func (c *recordingSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mutex.Lock()
+	c.keys = append(c.keys, sessionKey)
+	c.mutex.Unlock()
+	c.ClientSessionCache.Put(sessionKey, cs)
+}
+
+func (c *recordingSessionCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.keys
+}
+
+type delayedPacketConn struct {
+	net.PacketConn
+	delay time.Duration
+}
+
+func (c *delayedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	time.Sleep(c.delay)
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *countingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.written != nil {
+		atomic.AddInt32(c.written, 1)
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+type testGater struct {
+	mutex                          sync.Mutex
+	rejectDial, rejectAccept       bool
+	rejectSecured                  map[network.Direction]bool
+	dials, accepts, securedInbound []ma.Multiaddr
+}
+
+var _ ConnectionGater = &testGater{}
+
+func (g *testGater) InterceptAddrDial(_ peer.ID, addr ma.Multiaddr) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.dials = append(g.dials, addr)
+	return !g.rejectDial
+}
+
+func (g *testGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.accepts = append(g.accepts, addrs.LocalMultiaddr())
+	return !g.rejectAccept
+}
+
+func (g *testGater) InterceptSecured(dir network.Direction, _ peer.ID, addrs network.ConnMultiaddrs) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if dir == network.DirInbound {
+		g.securedInbound = append(g.securedInbound, addrs.RemoteMultiaddr())
+	}
+	return !g.rejectSecured[dir]
+}
+
+func (g *testGater) Accepts() []ma.Multiaddr {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.accepts
+}
+
+type recordedSpan struct {
+	name, parent string
+	attributes   map[string]string
+	err          error
+	ended        bool
+}
+
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+type tracerSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+type tracerSpanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	span := &recordedSpan{name: name, attributes: make(map[string]string)}
+	if parent, ok := ctx.Value(tracerSpanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, tracerSpanKey{}, span), &tracerSpan{tracer: t, span: span}
+}
+
+// Spans returns the spans that were ended.
+func (t *recordingTracer) Spans() []recordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var spans []recordedSpan
+	for _, s := range t.spans {
+		if s.ended {
+			spans = append(spans, *s)
+		}
+	}
+	return spans
+}
+
+func (s *tracerSpan) SetAttribute(key, value string) {
+	s.tracer.mutex.Lock()
+	s.span.attributes[key] = value
+	s.tracer.mutex.Unlock()
+}
+
+func (s *tracerSpan) RecordError(err error) {
+	s.tracer.mutex.Lock()
+	s.span.err = err
+	s.tracer.mutex.Unlock()
+}
+
+func (s *tracerSpan) End() {
+	s.tracer.mutex.Lock()
+	s.span.ended = true
+	s.tracer.mutex.Unlock()
+}
+
 var _ = Describe("Connection", func() {
 	var (
 		serverKey, clientKey ic.PrivKey
@@ -80,6 +242,8 @@ var _ = Describe("Connection", func() {
 		Expect(serverConn.LocalPrivateKey()).To(Equal(serverKey))
 		Expect(serverConn.RemotePeer()).To(Equal(clientID))
 		Expect(serverConn.RemotePublicKey()).To(Equal(clientKey.GetPublic()))
+		Expect(conn.(Conn).EstablishmentType()).To(Equal(EstablishmentFullHandshake))
+		Expect(serverConn.(Conn).EstablishmentType()).To(Equal(EstablishmentFullHandshake))
 	})
 
 	It("handshakes on IPv6", func() {
@@ -125,6 +289,1588 @@ var _ = Describe("Connection", func() {
 		Expect(data).To(Equal([]byte("foobar")))
 	})
 
+	It("opens and accepts unidirectional streams, if enabled", func() {
+		serverTransport, err := NewTransport(serverKey, WithMaxUniStreams(10))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+
+		str, err := conn.(Conn).OpenUniStream(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		str.Close()
+		sstr, err := serverConn.(Conn).AcceptUniStream(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(sstr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+
+		// the client didn't enable unidirectional streams
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = serverConn.(Conn).OpenUniStream(ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("limits the rate at which streams are opened", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		conn.(Conn).SetOutboundStreamRateLimit(rate.Every(50*time.Millisecond), 1)
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			_, err := conn.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+		}
+		// the first stream uses the burst, the other 4 have to wait for a token
+		Expect(time.Since(start)).To(BeNumerically(">", 190*time.Millisecond))
+	})
+
+	It("uses packet conn middlewares", func() {
+		var serverWritten, clientWritten, clientRead int32
+		serverTransport, err := NewTransport(serverKey, WithPacketConnMiddleware(func(c net.PacketConn) net.PacketConn {
+			return &countingPacketConn{PacketConn: c, written: &serverWritten}
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(
+			clientKey,
+			WithPacketConnMiddleware(func(c net.PacketConn) net.PacketConn {
+				return &countingPacketConn{PacketConn: c, written: &clientWritten}
+			}),
+			WithPacketConnMiddleware(func(c net.PacketConn) net.PacketConn {
+				// the second middleware wraps the first one
+				Expect(c).To(BeAssignableToTypeOf(&countingPacketConn{}))
+				return &countingPacketConn{PacketConn: c, read: &clientRead}
+			}),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(serverConnChan).Should(Receive())
+		Expect(atomic.LoadInt32(&serverWritten)).ToNot(BeZero())
+		Expect(atomic.LoadInt32(&clientWritten)).ToNot(BeZero())
+		Expect(atomic.LoadInt32(&clientRead)).ToNot(BeZero())
+	})
+
+	It("uses the packet conn factory", func() {
+		var mutex sync.Mutex
+		var created []string
+		factory := func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+			mutex.Lock()
+			created = append(created, laddr.String())
+			mutex.Unlock()
+			return net.ListenUDP(network, laddr)
+		}
+		serverTransport, err := NewTransport(serverKey, WithPacketConnFactory(factory))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithPacketConnFactory(factory))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(created).To(Equal([]string{"127.0.0.1:0", "0.0.0.0:0"}))
+	})
+
+	It("uses the new identity after swapping it", func() {
+		newClientID, newClientKey := createPeer()
+
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		serverConnChan := make(chan tpt.CapableConn)
+		go func() {
+			defer GinkgoRecover()
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				serverConnChan <- conn
+			}
+		}()
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn1, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn1 tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn1))
+		Expect(serverConn1.RemotePeer()).To(Equal(clientID))
+
+		Expect(clientTransport.SwapIdentity(newClientKey)).To(Succeed())
+		conn2, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn2.LocalPeer()).To(Equal(newClientID))
+		Expect(conn2.LocalPrivateKey()).To(Equal(newClientKey))
+		var serverConn2 tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn2))
+		Expect(serverConn2.RemotePeer()).To(Equal(newClientID))
+
+		// the old connection keeps its identity
+		Expect(conn1.LocalPeer()).To(Equal(clientID))
+		Expect(conn1.IsClosed()).To(BeFalse())
+		str, err := conn1.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		sstr, err := serverConn1.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(sstr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+	})
+
+	It("probes a peer", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		res, err := clientTransport.Probe(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RemotePeer).To(Equal(serverID))
+		Expect(res.RemotePublicKey).To(Equal(serverKey.GetPublic()))
+		Expect(res.RemoteMultiaddr).To(Equal(serverAddr))
+		Expect(res.HandshakeDuration).To(BeNumerically(">", 0))
+		Expect(res.TLSVersion).To(BeEquivalentTo(tls.VersionTLS13))
+		Expect(res.CipherSuite).ToNot(BeZero())
+		// the connection is closed right away
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+	})
+
+	It("doesn't probe using a reused connection", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			for {
+				if _, err := ln.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+
+		clientTransport, err := NewTransport(clientKey, WithConnectionReuse())
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = clientTransport.(Transport).Probe(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(conn.IsClosed).Should(BeFalse())
+		conn2, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn2).To(BeIdenticalTo(conn))
+	})
+
+	It("fails to probe a peer if the peer ID doesn't match", func() {
+		thirdPartyID, _ := createPeer()
+
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport.Probe(context.Background(), serverAddr, thirdPartyID)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to dial itself", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		_, err = serverTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).To(MatchError(ErrDialToSelf))
+		// also when dialing the wildcard address
+		port, err := serverAddr.ValueForProtocol(ma.P_UDP)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = serverTransport.Dial(context.Background(), ma.StringCast("/ip4/0.0.0.0/udp/"+port+"/quic"), serverID)
+		Expect(err).To(MatchError(ErrDialToSelf))
+		Consistently(serverConnChan).ShouldNot(Receive())
+	})
+
+	It("snapshots the stats of all open connections", func() {
+		serverID2, serverKey2 := createPeer()
+
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		serverTransport2, err := NewTransport(serverKey2)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr2, _ := runServer(serverTransport2, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientTransport.SnapshotStats()).To(BeEmpty())
+		c1, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		c2, err := clientTransport.Dial(context.Background(), serverAddr2, serverID2)
+		Expect(err).ToNot(HaveOccurred())
+		str, err := c1.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+
+		stats := clientTransport.SnapshotStats()
+		Expect(stats).To(HaveLen(2))
+		var peers []peer.ID
+		for _, s := range stats {
+			peers = append(peers, s.RemotePeer)
+			Expect(s.Opened).To(BeTemporally("~", time.Now(), 5*time.Second))
+			if s.RemotePeer == serverID {
+				Expect(s.RemoteMultiaddr).To(Equal(serverAddr))
+				Expect(s.StreamsOpened).To(BeEquivalentTo(1))
+				Expect(s.BytesSent).To(BeEquivalentTo(6))
+			}
+		}
+		Expect(peers).To(ConsistOf(serverID, serverID2))
+		Expect((<-serverConnChan).(Conn).Stats().RemotePeer).To(Equal(clientID))
+
+		Expect(c2.Close()).To(Succeed())
+		Eventually(clientTransport.SnapshotStats).Should(HaveLen(1))
+		Expect(clientTransport.SnapshotStats()[0].RemotePeer).To(Equal(serverID))
+	})
+
+	It("only accepts connections from allowed peers", func() {
+		_, otherClientKey := createPeer()
+
+		serverTransport, err := NewTransport(serverKey, WithAllowedInboundPeers(clientID))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		// dial from a peer that's not on the allow list
+		otherClientTransport, err := NewTransport(otherClientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := otherClientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(conn.IsClosed).Should(BeTrue())
+		Consistently(serverConnChan).ShouldNot(Receive())
+
+		// dial from an allowed peer
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
+	It("unblocks AcceptStream when the connection is closed", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+
+		errChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			_, err := conn.AcceptStream()
+			errChan <- err
+		}()
+		Consistently(errChan).ShouldNot(Receive())
+		Expect(conn.Close()).To(Succeed())
+		var acceptErr error
+		Eventually(errChan, 200*time.Millisecond).Should(Receive(&acceptErr))
+		Expect(acceptErr).To(Or(MatchError(ErrConnClosing), MatchError(ErrConnClosed)))
+		// once Close returned, the connection is closed
+		_, err = conn.AcceptStream()
+		Expect(err).To(MatchError(ErrConnClosed))
+		_, err = conn.OpenStream()
+		Expect(err).To(MatchError(ErrConnClosed))
+	})
+
+	It("accepts expired certificates, if configured to ignore the validity period", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		serverKey, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport.(*transport).tlsConf.Certificates = []tls.Certificate{createExpiredCertChain(rsaKey)}
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).To(HaveOccurred())
+
+		clientTransport, err = NewTransport(clientKey, WithIgnoreCertificateTimeValidity())
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.RemotePeer()).To(Equal(serverID))
+		Eventually(serverConnChan).Should(Receive())
+	})
+
+	It("enumerates streams", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+
+		// a stream that is closed by the peer
+		str1, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str1.Write([]byte("ping"))
+		Expect(err).ToNot(HaveOccurred())
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		b := make([]byte, 4)
+		_, err = io.ReadFull(sstr, b)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = sstr.Write([]byte("pong"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sstr.Close()).To(Succeed())
+		data, err := ioutil.ReadAll(str1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("pong")))
+		// an open stream
+		str2, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str2.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		// a stream that we closed
+		str3, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str3.Close()).To(Succeed())
+		// a stream that we reset
+		str4, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str4.Reset()).To(Succeed())
+
+		streams := conn.(Conn).Streams()
+		Expect(streams).To(HaveLen(3))
+		Expect(streams[0].ID).To(Equal(str1.(*stream).StreamID()))
+		Expect(streams[0].Direction).To(Equal(network.DirOutbound))
+		Expect(streams[0].Status).To(Equal(StreamHalfClosedRemote))
+		Expect(streams[0].BytesSent).To(BeEquivalentTo(4))
+		Expect(streams[0].BytesReceived).To(BeEquivalentTo(4))
+		Expect(streams[1].ID).To(Equal(str2.(*stream).StreamID()))
+		Expect(streams[1].Status).To(Equal(StreamOpen))
+		Expect(streams[1].BytesSent).To(BeEquivalentTo(6))
+		Expect(streams[2].ID).To(Equal(str3.(*stream).StreamID()))
+		Expect(streams[2].Status).To(Equal(StreamHalfClosedLocal))
+
+		serverStreams := serverConn.(Conn).Streams()
+		Expect(serverStreams).To(HaveLen(1))
+		Expect(serverStreams[0].Direction).To(Equal(network.DirInbound))
+		Expect(serverStreams[0].Status).To(Equal(StreamHalfClosedLocal))
+	})
+
+	It("records handshakes", func() {
+		var serverRecords, clientRecords []HandshakeRecord
+		var mutex sync.Mutex
+		serverTransport, err := NewTransport(serverKey, WithHandshakeRecorder(func(r HandshakeRecord) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			serverRecords = append(serverRecords, r)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithHandshakeRecorder(func(r HandshakeRecord) {
+			clientRecords = append(clientRecords, r)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		Expect(clientRecords).To(HaveLen(1))
+		r := clientRecords[0]
+		Expect(r.Error).ToNot(HaveOccurred())
+		Expect(r.Direction).To(Equal(network.DirOutbound))
+		Expect(r.RemotePeer).To(Equal(serverID))
+		Expect(r.RemoteMultiaddr).To(Equal(serverAddr))
+		Expect(r.LocalMultiaddr).To(Equal(conn.LocalMultiaddr()))
+		Expect(r.Start).ToNot(BeZero())
+		Expect(r.Duration).To(BeNumerically(">", 0))
+		Expect(r.TLSVersion).To(BeEquivalentTo(tls.VersionTLS13))
+		Expect(r.CipherSuite).ToNot(BeZero())
+		Expect(r.DidResume).To(BeFalse())
+		Expect(r.PeerCertificateFingerprints).To(HaveLen(2))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(serverRecords).To(HaveLen(1))
+		r = serverRecords[0]
+		Expect(r.Error).ToNot(HaveOccurred())
+		Expect(r.Direction).To(Equal(network.DirInbound))
+		Expect(r.RemotePeer).To(Equal(clientID))
+		Expect(r.RemoteMultiaddr).To(Equal(conn.LocalMultiaddr()))
+		Expect(r.LocalMultiaddr).To(Equal(serverAddr))
+		Expect(r.TLSVersion).To(BeEquivalentTo(tls.VersionTLS13))
+		Expect(r.PeerCertificateFingerprints).To(HaveLen(2))
+	})
+
+	It("closes connections after their maximum lifetime", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithMaxConnectionLifetime(200*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+		Consistently(conn.IsClosed, 100*time.Millisecond).Should(BeFalse())
+		Eventually(conn.IsClosed).Should(BeTrue())
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		_, err = conn.OpenStream()
+		Expect(err).To(MatchError(ErrConnClosed))
+	})
+
+	It("measures the duration of the handshake phases", func() {
+		const delay = 25 * time.Millisecond
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		var recorded HandshakeTimings
+		clientTransport, err := NewTransport(
+			clientKey,
+			WithPacketConnMiddleware(func(c net.PacketConn) net.PacketConn {
+				return &delayedPacketConn{PacketConn: c, delay: delay}
+			}),
+			WithHandshakeRecorder(func(r HandshakeRecord) { recorded = r.Timings }),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+
+		timings := conn.(Conn).HandshakeTimings()
+		Expect(timings).To(Equal(recorded))
+		// the client's Initial is delayed before the server can respond
+		Expect(timings.ServerFlight).To(BeNumerically(">=", delay))
+		Expect(timings.Confirmation).To(BeNumerically(">", 0))
+		Expect(timings.SocketAcquisition + timings.ServerFlight + timings.Confirmation).To(BeNumerically("~", timings.Total, time.Millisecond))
+	})
+
+	It("applies the inbound IP policy", func() {
+		var consulted []net.IP
+		var mutex sync.Mutex
+		_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+		Expect(err).ToNot(HaveOccurred())
+		_, documentation, err := net.ParseCIDR("192.0.2.0/24")
+		Expect(err).ToNot(HaveOccurred())
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		// accept loopback addresses
+		serverTransport, err := NewTransport(serverKey, WithInboundIPPolicy(func(ip net.IP) bool {
+			mutex.Lock()
+			consulted = append(consulted, ip)
+			mutex.Unlock()
+			return loopback.Contains(ip)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Eventually(serverConnChan).Should(Receive())
+		mutex.Lock()
+		Expect(consulted).To(HaveLen(1))
+		Expect(consulted[0].Equal(net.IPv4(127, 0, 0, 1))).To(BeTrue())
+		mutex.Unlock()
+
+		// only accept addresses from the documentation range
+		serverTransport, err = NewTransport(serverKey, WithInboundIPPolicy(documentation.Contains))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan = runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).To(HaveOccurred())
+		Consistently(serverConnChan).ShouldNot(Receive())
+	})
+
+	It("calls the OnConnected callback once for every connection", func() {
+		serverConns := make(chan tpt.CapableConn, 10)
+		serverTransport, err := NewTransport(serverKey, WithOnConnected(func(c tpt.CapableConn) { serverConns <- c }))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		var clientConns []tpt.CapableConn
+		clientTransport, err := NewTransport(clientKey, WithOnConnected(func(c tpt.CapableConn) { clientConns = append(clientConns, c) }))
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 2; i++ {
+			conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Expect(clientConns).To(HaveLen(i + 1))
+			Expect(clientConns[i]).To(Equal(conn))
+			serverConn := <-serverConnChan
+			defer serverConn.Close()
+			Expect(serverConns).To(Receive(Equal(serverConn)))
+		}
+		Expect(serverConns).ToNot(Receive())
+	})
+
+	It("resolves simultaneous connects", func() {
+		serverTransport, err := NewTransport(serverKey, WithDuplicateConnectionPolicy(KeepLowerInitiator))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithDuplicateConnectionPolicy(KeepLowerInitiator))
+		Expect(err).ToNot(HaveOccurred())
+		clientAddr, clientConnChan := runServer(clientTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		// the client dials the server ...
+		clientConn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		serverConn := <-serverConnChan
+		// ... and the server dials the client
+		conn, err := serverTransport.Dial(context.Background(), clientAddr, clientID)
+		if clientID < serverID {
+			// the connection initiated by the client is kept
+			Expect(err).To(MatchError(ErrDuplicateConnection))
+			Consistently(clientConnChan).ShouldNot(Receive())
+			Expect(clientConn.IsClosed()).To(BeFalse())
+			Expect(serverConn.IsClosed()).To(BeFalse())
+		} else {
+			// the connection initiated by the server is kept
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Eventually(clientConnChan).Should(Receive())
+			Eventually(clientConn.IsClosed).Should(BeTrue())
+			Eventually(serverConn.IsClosed).Should(BeTrue())
+			Expect(conn.IsClosed()).To(BeFalse())
+		}
+	})
+
+	It("dials and accepts connections when runtime tracing is enabled", func() {
+		buf := &bytes.Buffer{}
+		Expect(trace.Start(buf)).To(Succeed())
+		defer trace.Stop()
+
+		serverTransport, err := NewTransport(serverKey, WithRuntimeTrace())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithRuntimeTrace())
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
+	It("creates spans for dials and accepted connections", func() {
+		serverTracer, clientTracer := &recordingTracer{}, &recordingTracer{}
+		serverTransport, err := NewTransport(serverKey, WithTracer(serverTracer))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithTracer(clientTracer))
+		Expect(err).ToNot(HaveOccurred())
+		ctx, parent := clientTracer.Start(context.Background(), "parent")
+		conn, err := clientTransport.Dial(ctx, serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		parent.End()
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		spans := clientTracer.Spans()
+		Expect(spans).To(HaveLen(5))
+		Expect(spans[0].name).To(Equal("parent"))
+		Expect(spans[1].name).To(Equal("quic dial"))
+		Expect(spans[1].parent).To(Equal("parent"))
+		Expect(spans[1].attributes).To(HaveKeyWithValue("peer", serverID.Pretty()))
+		Expect(spans[1].err).ToNot(HaveOccurred())
+		for i, name := range []string{"resolve address", "acquire socket", "handshake"} {
+			Expect(spans[i+2].name).To(Equal(name))
+			Expect(spans[i+2].parent).To(Equal("quic dial"))
+		}
+		Eventually(serverTracer.Spans).Should(HaveLen(2))
+		spans = serverTracer.Spans()
+		Expect(spans[0].name).To(Equal("quic accept"))
+		Expect(spans[0].attributes).To(HaveKeyWithValue("peer", clientID.Pretty()))
+		Expect(spans[1].name).To(Equal("setup connection"))
+		Expect(spans[1].parent).To(Equal("quic accept"))
+
+		// failed dials are recorded
+		_, err = clientTransport.Dial(context.Background(), serverAddr, clientID)
+		Expect(err).To(MatchError(ErrDialToSelf))
+		spans = clientTracer.Spans()
+		Expect(spans[len(spans)-1].name).To(Equal("quic dial"))
+		Expect(spans[len(spans)-1].err).To(MatchError(ErrDialToSelf))
+	})
+
+	It("closes inbound connections that don't use any streams within the deadline", func() {
+		serverTransport, err := NewTransport(serverKey, WithInboundActivityDeadline(200*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		// a connection that doesn't use any streams
+		idleConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer idleConn.Close()
+		idleServerConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+
+		// a connection that uses a stream
+		activeConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer activeConn.Close()
+		activeServerConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		str, err := activeConn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = activeServerConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(idleServerConn.IsClosed).Should(BeTrue())
+		Eventually(idleConn.IsClosed).Should(BeTrue())
+		Consistently(activeServerConn.IsClosed).Should(BeFalse())
+		Expect(activeConn.IsClosed()).To(BeFalse())
+	})
+
+	It("uses the configured stream limit", func() {
+		serverTransport, err := NewTransport(serverKey, WithQUICConfig(&quic.Config{KeepAlive: true}), WithMaxStreams(1))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		_, err = conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		opened := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := conn.OpenStream()
+			Expect(err).To(HaveOccurred())
+			close(opened)
+		}()
+		Consistently(opened).ShouldNot(BeClosed())
+		Expect(conn.Close()).To(Succeed())
+		Eventually(opened).Should(BeClosed())
+		Expect(quicConfig.MaxIncomingStreams).To(Equal(1000))
+	})
+
+	It("closes the transport", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer (<-serverConnChan).Close()
+
+		Expect(clientTransport.Close()).To(Succeed())
+		Eventually(conn.IsClosed).Should(BeTrue())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).To(MatchError(ErrTransportClosed))
+	})
+
+	It("dials from the listening socket", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := clientTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.LocalMultiaddr()).To(Equal(ln.Multiaddr()))
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+		Expect(serverConn.RemoteMultiaddr()).To(Equal(ln.Multiaddr()))
+	})
+
+	It("doesn't dial from the listening socket, if disabled", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithoutListenSocketReuse())
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := clientTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+		Expect(serverConn.RemoteMultiaddr()).ToNot(Equal(ln.Multiaddr()))
+	})
+
+	It("applies per-dial configuration overrides", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		keyLog := &bytes.Buffer{}
+		ctx := WithTLSConfigOverride(context.Background(), func(conf *tls.Config) { conf.KeyLogWriter = keyLog })
+		conn, err := clientTransport.Dial(ctx, serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+		Expect(keyLog.Len()).ToNot(BeZero())
+
+		// dial a socket that never responds
+		pconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer pconn.Close()
+		addr, err := toQuicMultiaddr(pconn.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		ctx = WithQUICConfigOverride(context.Background(), func(conf *quic.Config) { conf.HandshakeTimeout = 200 * time.Millisecond })
+		start := time.Now()
+		_, err = clientTransport.Dial(ctx, addr, serverID)
+		Expect(errors.Is(err, ErrHandshakeTimeout)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(clientTransport.(*transport).config.quicConfig.HandshakeTimeout).To(BeZero())
+	})
+
+	It("binds the dialing socket to the configured local address", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithDialLocalAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+		ip, err := conn.LocalMultiaddr().ValueForProtocol(ma.P_IP4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ip).To(Equal("127.0.0.1"))
+	})
+
+	It("handshakes if the client has to validate its address", func() {
+		serverTransport, err := NewTransport(serverKey, WithAddressValidationThreshold(0))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
+	It("resumes sessions", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		cache := &recordingSessionCache{ClientSessionCache: tls.NewLRUClientSessionCache(10)}
+		clientTransport, err := NewTransport(clientKey, WithClientSessionCache(cache))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		Expect(conn.(Conn).EstablishmentType()).To(Equal(EstablishmentFullHandshake))
+		// the session ticket is sent after the handshake
+		Eventually(cache.Keys).ShouldNot(BeEmpty())
+		Expect(cache.Keys()[0]).To(HavePrefix(string(serverID)))
+
+		conn, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn, err = ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		Expect(conn.(Conn).EstablishmentType()).To(Equal(EstablishmentResumed))
+		Expect(conn.RemotePublicKey()).To(Equal(serverKey.GetPublic()))
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
+	It("rotates the certificate", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		var serverCerts, clientCerts [][sha256.Size]byte
+		clientTransport, err := NewTransport(clientKey, WithHandshakeRecorder(func(r HandshakeRecord) {
+			serverCerts = append(serverCerts, r.PeerCertificateFingerprints[0])
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		dial := func() (tpt.CapableConn, tpt.CapableConn) {
+			c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			serverConn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			certs := serverConn.(*conn).sess.ConnectionState().PeerCertificates
+			clientCerts = append(clientCerts, sha256.Sum256(certs[0].Raw))
+			return c, serverConn
+		}
+
+		conn1, serverConn1 := dial()
+		defer conn1.Close()
+		defer serverConn1.Close()
+		Expect(serverTransport.RotateCertificate()).To(Succeed())
+		Expect(clientTransport.RotateCertificate()).To(Succeed())
+		conn2, serverConn2 := dial()
+		defer conn2.Close()
+		defer serverConn2.Close()
+
+		Expect(serverCerts).To(HaveLen(2))
+		Expect(serverCerts[0]).ToNot(Equal(serverCerts[1]))
+		Expect(clientCerts).To(HaveLen(2))
+		Expect(clientCerts[0]).ToNot(Equal(clientCerts[1]))
+		Expect(conn2.RemotePeer()).To(Equal(serverID))
+		Expect(serverConn2.RemotePeer()).To(Equal(clientID))
+		// existing connections are not affected
+		Expect(conn1.IsClosed()).To(BeFalse())
+		Expect(serverConn1.IsClosed()).To(BeFalse())
+	})
+
+	It("handshakes using the libp2p TLS specification", func() {
+		var serverRecords []HandshakeRecord
+		var mutex sync.Mutex
+		serverTransport, err := NewTransport(serverKey, WithLibp2pTLS(), WithHandshakeRecorder(func(r HandshakeRecord) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			serverRecords = append(serverRecords, r)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		for _, opts := range [][]Option{{WithLibp2pTLS()}, nil} {
+			clientTransport, err := NewTransport(clientKey, opts...)
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			serverConn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			defer serverConn.Close()
+			Expect(conn.RemotePublicKey()).To(Equal(serverKey.GetPublic()))
+			Expect(serverConn.RemotePublicKey()).To(Equal(clientKey.GetPublic()))
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(serverRecords).To(HaveLen(2))
+		// The client using the libp2p TLS specification offers the "libp2p" ALPN,
+		// the other client uses the default handshake.
+		Expect(serverRecords[0].NegotiatedProtocol).To(Equal("libp2p"))
+		Expect(serverRecords[0].PeerCertificateFingerprints).To(HaveLen(1))
+		Expect(serverRecords[1].NegotiatedProtocol).ToNot(Equal("libp2p"))
+		Expect(serverRecords[1].PeerCertificateFingerprints).To(HaveLen(2))
+	})
+
+	It("only connects nodes in the same private network", func() {
+		psk := bytes.Repeat([]byte{0x42}, 32)
+		serverTransport, err := NewTransport(serverKey, WithPrivateNetwork(psk))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		for _, opts := range [][]Option{nil, {WithPrivateNetwork(bytes.Repeat([]byte{0x13}, 32))}} {
+			clientTransport, err := NewTransport(clientKey, opts...)
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			_, err = clientTransport.Dial(ctx, ln.Multiaddr(), serverID)
+			cancel()
+			Expect(err).To(HaveOccurred())
+		}
+
+		clientTransport, err := NewTransport(clientKey, WithPrivateNetwork(psk))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+	})
+
+	Context("connection gating", func() {
+		var serverGater, clientGater *testGater
+
+		BeforeEach(func() {
+			serverGater = &testGater{rejectSecured: make(map[network.Direction]bool)}
+			clientGater = &testGater{rejectSecured: make(map[network.Direction]bool)}
+		})
+
+		dial := func() (tpt.CapableConn, tpt.Listener, error) {
+			serverTransport, err := NewTransport(serverKey, WithConnectionGater(serverGater))
+			Expect(err).ToNot(HaveOccurred())
+			ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			clientTransport, err := NewTransport(clientKey, WithConnectionGater(clientGater))
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			conn, err := clientTransport.Dial(ctx, ln.Multiaddr(), serverID)
+			return conn, ln, err
+		}
+
+		It("establishes connections accepted by the gaters", func() {
+			conn, ln, err := dial()
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			defer conn.Close()
+			serverConn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			defer serverConn.Close()
+			Expect(clientGater.dials).To(Equal([]ma.Multiaddr{ln.Multiaddr()}))
+			Expect(serverGater.Accepts()).To(Equal([]ma.Multiaddr{ln.Multiaddr()}))
+			Expect(serverGater.securedInbound).To(Equal([]ma.Multiaddr{conn.LocalMultiaddr()}))
+		})
+
+		It("rejects dials before dialing", func() {
+			clientGater.rejectDial = true
+			_, ln, err := dial()
+			defer ln.Close()
+			Expect(err).To(MatchError(ErrGated))
+			Consistently(serverGater.Accepts).Should(BeEmpty())
+		})
+
+		It("rejects dials after the handshake", func() {
+			clientGater.rejectSecured[network.DirOutbound] = true
+			_, ln, err := dial()
+			defer ln.Close()
+			Expect(err).To(MatchError(ErrGated))
+		})
+
+		It("rejects connections before the handshake", func() {
+			serverGater.rejectAccept = true
+			_, ln, err := dial()
+			defer ln.Close()
+			Expect(err).To(HaveOccurred())
+			Expect(serverGater.Accepts()).To(HaveLen(1))
+		})
+
+		It("rejects connections after the handshake", func() {
+			serverGater.rejectSecured[network.DirInbound] = true
+			conn, ln, err := dial()
+			defer ln.Close()
+			// the client completes the handshake before the server checks the peer
+			Expect(err).ToNot(HaveOccurred())
+			go ln.Accept() // blocks until the listener is closed, since the connection is rejected
+			Eventually(conn.IsClosed).Should(BeTrue())
+		})
+	})
+
+	It("limits the number of streams using the resource manager", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithResourceManager(NewResourceManager(ResourceLimits{StreamsPerPeer: 1})))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		str, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = conn.OpenStream()
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+		Expect(str.Reset()).To(Succeed())
+		_, err = conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("releases streams that were only closed for writing when the connection is closed", func() {
+		rm := NewResourceManager(ResourceLimits{})
+		serverTransport, err := NewTransport(serverKey, WithResourceManager(rm))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		str, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sstr.Close()).To(Succeed())
+		Expect(serverConn.(Conn).Streams()).To(HaveLen(1))
+		streamsInUse := func() int {
+			rm.(*resourceManager).mutex.Lock()
+			defer rm.(*resourceManager).mutex.Unlock()
+			return rm.(*resourceManager).total.streams
+		}
+		Expect(streamsInUse()).To(Equal(1))
+
+		Expect(conn.Close()).To(Succeed())
+		Eventually(serverConn.(Conn).Streams).Should(BeEmpty())
+		Expect(streamsInUse()).To(BeZero())
+	})
+
+	It("collects metrics", func() {
+		metrics := NewMetrics()
+		serverTransport, err := NewTransport(serverKey, WithMetrics(metrics))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithMetrics(metrics))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		Expect(atomic.LoadUint64(&metrics.dials)).To(BeEquivalentTo(1))
+		Expect(atomic.LoadUint64(&metrics.handshakesAccepted)).To(BeEquivalentTo(1))
+		Expect(atomic.LoadInt64(&metrics.connsOutbound)).To(BeEquivalentTo(1))
+		Expect(atomic.LoadInt64(&metrics.connsInbound)).To(BeEquivalentTo(1))
+		Expect(atomic.LoadUint64(&metrics.packetsSent)).ToNot(BeZero())
+		Expect(atomic.LoadUint64(&metrics.packetsReceived)).ToNot(BeZero())
+		metrics.mutex.Lock()
+		Expect(metrics.handshakeDurationsOutbound.num).To(BeEquivalentTo(1))
+		Expect(metrics.handshakeDurationsInbound.num).To(BeEquivalentTo(1))
+		metrics.mutex.Unlock()
+		Expect(conn.Close()).To(Succeed())
+		Eventually(func() int64 { return atomic.LoadInt64(&metrics.connsOutbound) }).Should(BeZero())
+	})
+
+	It("writes the TLS secrets to the key log", func() {
+		Expect(os.Setenv(KeyLogEnvKey, "1")).To(Succeed())
+		defer os.Unsetenv(KeyLogEnvKey)
+		serverKeyLog, clientKeyLog := &bytes.Buffer{}, &bytes.Buffer{}
+		serverTransport, err := NewTransport(serverKey, WithKeyLogWriter(serverKeyLog))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithKeyLogWriter(clientKeyLog))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+		Expect(clientKeyLog.String()).To(ContainSubstring("CLIENT_HANDSHAKE_TRAFFIC_SECRET"))
+		Expect(serverKeyLog.String()).To(ContainSubstring("CLIENT_HANDSHAKE_TRAFFIC_SECRET"))
+	})
+
+	It("logs dials, listeners and accepted connections", func() {
+		serverLogger, clientLogger := &recordingLogger{}, &recordingLogger{}
+		serverTransport, err := NewTransport(serverKey, WithLogger(serverLogger))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithLogger(clientLogger))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+
+		Expect(serverLogger.Messages()).To(ContainElement(fmt.Sprintf("listening addr=%s", serverAddr)))
+		Expect(serverLogger.Messages()).To(ContainElement(HavePrefix(fmt.Sprintf("accepted connection addr=%s peer=%s", serverAddr, clientID))))
+		Expect(clientLogger.Messages()).To(ContainElement(HavePrefix("created socket for dialing network=udp4")))
+		Expect(clientLogger.Messages()).To(ContainElement(HavePrefix(fmt.Sprintf("dialed connection peer=%s addr=%s", serverID, serverAddr))))
+	})
+
+	It("receives packets in batches", func() {
+		serverTransport, err := NewTransport(serverKey, WithReceiveBatchSize(16))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey, WithReceiveBatchSize(16))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+
+		data := bytes.Repeat([]byte("foobar"), 50000)
+		str, err := conn.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+		sstr, err := serverConn.AcceptStream()
+		Expect(err).ToNot(HaveOccurred())
+		received, err := ioutil.ReadAll(sstr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received).To(Equal(data))
+	})
+
+	It("reports the negotiated QUIC version", func() {
+		serverTransport, err := NewTransport(serverKey, WithVersions(VersionDraft22))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		defer serverConn.Close()
+		Expect(conn.(Conn).Version()).To(Equal(VersionDraft22))
+		Expect(serverConn.(Conn).Version()).To(Equal(VersionDraft22))
+	})
+
+	It("hole punches", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientAddr, clientConnChan := runServer(clientTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		serverDialed := make(chan tpt.CapableConn)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := serverTransport.Dial(WithHolePunch(ctx, false), clientAddr, clientID)
+			Expect(err).ToNot(HaveOccurred())
+			serverDialed <- conn
+		}()
+		holePunches := &serverTransport.(*transport).holePunches
+		Eventually(func() int {
+			holePunches.mutex.Lock()
+			defer holePunches.mutex.Unlock()
+			return len(holePunches.waiting)
+		}).Should(Equal(1))
+
+		conn, err := clientTransport.Dial(WithHolePunch(ctx, true), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.LocalMultiaddr()).To(Equal(clientAddr))
+		var serverConn tpt.CapableConn
+		Eventually(serverDialed).Should(Receive(&serverConn))
+		defer serverConn.Close()
+		Expect(serverConn.RemotePeer()).To(Equal(clientID))
+		Expect(serverConn.RemoteMultiaddr()).To(Equal(clientAddr))
+		// the connection is returned by Dial, not by Accept
+		Consistently(serverConnChan).ShouldNot(Receive())
+		Expect(clientConnChan).ToNot(Receive())
+	})
+
+	It("provides the hooks for hole punch coordination", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		clientAddr, _ := runServer(clientTransport, "/ip4/127.0.0.1/udp/0/quic")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ctx = WithHolePunch(ctx, true)
+		laddr, err := clientTransport.(Transport).LocalAddrForDial(ctx, serverAddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(laddr).To(Equal(clientAddr))
+
+		received := make(chan time.Time)
+		go func() {
+			defer GinkgoRecover()
+			Expect(serverTransport.(Transport).WaitForPacket(ctx, clientAddr)).To(Succeed())
+			received <- time.Now()
+		}()
+		dialAt := time.Now().Add(200 * time.Millisecond)
+		conn, err := clientTransport.Dial(WithDialAt(ctx, dialAt), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+		var receivedAt time.Time
+		Eventually(received).Should(Receive(&receivedAt))
+		Expect(receivedAt).To(BeTemporally(">=", dialAt))
+	})
+
+	It("reports the observed addresses", func() {
+		serverObserved := make(chan ObservedAddrs, 1)
+		serverTransport, err := NewTransport(serverKey, WithObservedAddrsHandler(func(addrs ObservedAddrs) { serverObserved <- addrs }))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/0.0.0.0/udp/0/quic")
+		serverAddr = ma.StringCast(strings.Replace(serverAddr.String(), "0.0.0.0", "127.0.0.1", 1))
+
+		clientObserved := make(chan ObservedAddrs, 1)
+		clientTransport, err := NewTransport(clientKey, WithObservedAddrsHandler(func(addrs ObservedAddrs) { clientObserved <- addrs }))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		defer (<-serverConnChan).Close()
+
+		var addrs ObservedAddrs
+		Expect(clientObserved).To(Receive(&addrs))
+		Expect(addrs.Peer).To(Equal(serverID))
+		Expect(addrs.Direction).To(Equal(network.DirOutbound))
+		Expect(addrs.Remote).To(Equal(serverAddr))
+		// the sockets are bound to the unspecified address, but the packets are sent to 127.0.0.1
+		Expect(addrs.Local.String()).To(HavePrefix("/ip4/127.0.0.1/udp/"))
+		clientAddr := addrs.Local
+		Expect(serverObserved).To(Receive(&addrs))
+		Expect(addrs.Peer).To(Equal(clientID))
+		Expect(addrs.Direction).To(Equal(network.DirInbound))
+		Expect(addrs.Local).To(Equal(serverAddr))
+		Expect(addrs.Remote).To(Equal(clientAddr))
+	})
+
+	It("prefers IPv6 when dialing multiple addresses", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr4, _ := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		serverAddr6, serverConnChan := runServer(serverTransport, "/ip6/::1/udp/0/quic")
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.(Transport).DialMany(context.Background(), []ma.Multiaddr{serverAddr4, serverAddr6}, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.RemoteMultiaddr()).To(Equal(serverAddr6))
+		Eventually(serverConnChan).Should(Receive())
+	})
+
+	It("falls back to IPv4 if the IPv6 dial doesn't complete", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		// a socket that never responds
+		blackHole, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+		Expect(err).ToNot(HaveOccurred())
+		defer blackHole.Close()
+		blackHoleAddr, err := toQuicMultiaddr(blackHole.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		start := time.Now()
+		conn, err := clientTransport.(Transport).DialMany(context.Background(), []ma.Multiaddr{serverAddr, blackHoleAddr}, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(time.Since(start)).To(BeNumerically(">=", happyEyeballsDelay))
+		Expect(conn.RemoteMultiaddr()).To(Equal(serverAddr))
+		Eventually(serverConnChan).Should(Receive())
+		// the IPv6 address was dialed first
+		blackHole.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = blackHole.ReadFrom(make([]byte, maxPacketSize))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("accepts connections on all addresses when listening on multiple addresses", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.(Transport).ListenMany([]ma.Multiaddr{
+			ma.StringCast("/ip4/127.0.0.1/udp/0/quic"),
+			ma.StringCast("/ip6/::1/udp/0/quic"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		serverAddrs := ln.Multiaddrs()
+		Expect(serverAddrs).To(HaveLen(2))
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		for _, addr := range serverAddrs {
+			conn, err := clientTransport.Dial(context.Background(), addr, serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			serverConn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serverConn.LocalMultiaddr()).To(Equal(addr))
+			Expect(serverConn.RemotePeer()).To(Equal(clientID))
+		}
+		Expect(ln.Close()).To(Succeed())
+		_, err = ln.Accept()
+		Expect(err).To(MatchError(errListenerClosed))
+	})
+
+	It("drains the listener", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+
+		drained := make(chan error, 1)
+		go func() { drained <- ln.(Listener).Drain(context.Background()) }()
+		_, err = ln.Accept()
+		Expect(err).To(MatchError(errListenerDraining))
+		// new handshakes are rejected
+		_, otherKey := createPeer()
+		otherTransport, err := NewTransport(otherKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = otherTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).To(HaveOccurred())
+		// the existing connection is kept open
+		Consistently(drained).ShouldNot(Receive())
+		Expect(serverConn.IsClosed()).To(BeFalse())
+		Expect(conn.Close()).To(Succeed())
+		Eventually(drained, 5*time.Second).Should(Receive(BeNil()))
+	})
+
+	It("closes the remaining connections when draining the listener times out", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		Expect(ln.(Listener).Drain(ctx)).To(MatchError(context.DeadlineExceeded))
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		Eventually(conn.IsClosed).Should(BeTrue())
+	})
+
+	It("times out handshakes", func() {
+		clientTransport, err := NewTransport(clientKey, WithHandshakeTimeout(200*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientTransport.(*transport).config.quicConfig.HandshakeTimeout).To(Equal(200 * time.Millisecond))
+		// dial a socket that never responds
+		pconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer pconn.Close()
+		addr, err := toQuicMultiaddr(pconn.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		start := time.Now()
+		_, err = clientTransport.Dial(context.Background(), addr, serverID)
+		Expect(errors.Is(err, ErrHandshakeTimeout)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("closes idle connections if keep-alives are disabled", func() {
+		serverTransport, err := NewTransport(serverKey, WithIdleTimeout(500*time.Millisecond), WithKeepAlive(false))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithIdleTimeout(500*time.Millisecond), WithKeepAlive(false))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientTransport.(*transport).config.quicConfig.KeepAlive).To(BeFalse())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		serverConn := <-serverConnChan
+		Eventually(conn.IsClosed, 2*time.Second).Should(BeTrue())
+		Eventually(serverConn.IsClosed, 2*time.Second).Should(BeTrue())
+	})
+
+	It("reuses connections, if enabled", func() {
+		serverTransport, err := NewTransport(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithConnectionReuse())
+		Expect(err).ToNot(HaveOccurred())
+
+		const num = 5
+		conns := make(chan tpt.CapableConn, num)
+		for i := 0; i < num; i++ {
+			go func() {
+				defer GinkgoRecover()
+				conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+				Expect(err).ToNot(HaveOccurred())
+				conns <- conn
+			}()
+		}
+		conn := <-conns
+		defer conn.Close()
+		for i := 1; i < num; i++ {
+			Expect(<-conns).To(BeIdenticalTo(conn))
+		}
+		Eventually(serverConnChan).Should(Receive())
+		// later dials reuse the connection, ...
+		conn2, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn2).To(BeIdenticalTo(conn))
+		// ... unless a new connection is requested
+		newConn, err := clientTransport.Dial(WithNewConnection(context.Background()), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer newConn.Close()
+		Expect(newConn).ToNot(BeIdenticalTo(conn))
+		// closed connections are not reused
+		Expect(conn.Close()).To(Succeed())
+		conn3, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn3.Close()
+		Expect(conn3).ToNot(BeIdenticalTo(conn))
+		Expect(conn3).ToNot(BeIdenticalTo(newConn))
+	})
+
+	It("limits the handshake rate", func() {
+		serverTransport, err := NewTransport(serverKey, WithHandshakeRateLimit(0.001, 1, 32, 128))
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Eventually(serverConnChan).Should(Receive())
+		_, err = clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(errors.Is(err, ErrConnectionRefused)).To(BeTrue())
+	})
+
+	It("limits the number of inbound connections", func() {
+		serverTransport, err := NewTransport(serverKey, WithMaxInboundConns(1, 1))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		serverConns := make(chan tpt.CapableConn, 2)
+		go func() {
+			defer GinkgoRecover()
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				serverConns <- conn
+			}
+		}()
+
+		clientTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := <-serverConns
+		_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(errors.Is(err, ErrConnectionRefused)).To(BeTrue())
+		// once the connection is closed, new connections are accepted again
+		Expect(conn.Close()).To(Succeed())
+		Eventually(serverConn.IsClosed).Should(BeTrue())
+		Eventually(func() error {
+			conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}).Should(Succeed())
+	})
+
+	It("handshakes using the libp2p TLS specification, using Ed25519 keys", func() {
+		edServerKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		edServerID, err := peer.IDFromPrivateKey(edServerKey)
+		Expect(err).ToNot(HaveOccurred())
+		edClientKey, _, err := ic.GenerateEd25519Key(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		serverTransport, err := NewTransport(edServerKey, WithLibp2pTLS())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(edClientKey, WithLibp2pTLS())
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, edServerID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.RemotePublicKey()).To(Equal(edServerKey.GetPublic()))
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		defer serverConn.Close()
+		Expect(serverConn.RemotePublicKey()).To(Equal(edClientKey.GetPublic()))
+
+		// clients using the default handshake can't connect
+		legacyTransport, err := NewTransport(clientKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = legacyTransport.Dial(context.Background(), serverAddr, edServerID)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("handshakes using the libp2p TLS specification, when ignoring the certificate validity period", func() {
+		serverTransport, err := NewTransport(serverKey, WithLibp2pTLS(), WithIgnoreCertificateTimeValidity())
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr, serverConnChan := runServer(serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+		clientTransport, err := NewTransport(clientKey, WithLibp2pTLS(), WithIgnoreCertificateTimeValidity())
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := clientTransport.Dial(context.Background(), serverAddr, serverID)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.RemotePublicKey()).To(Equal(serverKey.GetPublic()))
+		var serverConn tpt.CapableConn
+		Eventually(serverConnChan).Should(Receive(&serverConn))
+		defer serverConn.Close()
+		Expect(serverConn.RemotePublicKey()).To(Equal(clientKey.GetPublic()))
+	})
+
 	It("fails if the peer ID doesn't match", func() {
 		thirdPartyID, _ := createPeer()
 
@@ -138,6 +1884,11 @@ var _ = Describe("Connection", func() {
 		_, err = clientTransport.Dial(context.Background(), serverAddr, thirdPartyID)
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("CRYPTO_ERROR"))
+		Expect(errors.Is(err, ErrPeerIDMismatch)).To(BeTrue())
+		var dialErr *DialError
+		Expect(errors.As(err, &dialErr)).To(BeTrue())
+		Expect(dialErr.Peer).To(Equal(thirdPartyID))
+		Expect(dialErr.Addr).To(Equal(serverAddr))
 		Consistently(serverConnChan).ShouldNot(Receive())
 	})
 
@@ -6,6 +6,8 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"math/big"
 	"time"
@@ -20,6 +22,25 @@ const hostname = "quic.ipfs"
 
 const certValidityPeriod = 180 * 24 * time.Hour
 
+// alpn is the ALPN used by the handshake of the libp2p TLS specification.
+const alpn = "libp2p"
+
+// errDefaultHandshakeUnsupported is returned to clients using the default handshake,
+// if the identity key can only be used with the handshake of the libp2p TLS specification.
+var errDefaultHandshakeUnsupported = errors.New("the default handshake is not supported for this key type")
+
+// certificatePrefix is prepended to the certificate's public key before it is signed with the host key.
+const certificatePrefix = "libp2p-tls-handshake:"
+
+// extensionID is the OID of the libp2p Public Key Extension.
+var extensionID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 1, 1}
+
+// signedKey is the value of the libp2p Public Key Extension.
+type signedKey struct {
+	PubKey    []byte
+	Signature []byte
+}
+
 func generateConfig(privKey ic.PrivKey) (*tls.Config, error) {
 	key, hostCert, err := keyToCertificate(privKey)
 	if err != nil {
@@ -47,10 +68,17 @@ func generateConfig(privKey ic.PrivKey) (*tls.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	// quic-go creates a new TLS config for every connection.
+	// Set the session ticket key explicitly, so that sessions can be resumed on all connections.
+	var sessionTicketKey [32]byte
+	if _, err := rand.Read(sessionTicketKey[:]); err != nil {
+		return nil, err
+	}
 	return &tls.Config{
 		ServerName:         hostname,
 		InsecureSkipVerify: true, // This is not insecure here. We will verify the cert chain ourselves.
 		ClientAuth:         tls.RequireAnyClientCert,
+		SessionTicketKey:   sessionTicketKey,
 		Certificates: []tls.Certificate{{
 			Certificate: [][]byte{cert.Raw, hostCert.Raw},
 			PrivateKey:  ephemeralKey,
@@ -58,14 +86,166 @@ func generateConfig(privKey ic.PrivKey) (*tls.Config, error) {
 	}, nil
 }
 
-func getRemotePubKey(chain []*x509.Certificate) (ic.PubKey, error) {
+// generateTLSConfigs generates the TLS configs for the identity key, and applies the config to them.
+// The config for the handshake of the libp2p TLS specification is nil, unless enabled by WithLibp2pTLS.
+// The default handshake only supports RSA keys. If the handshake of the specification is enabled,
+// the config for the default handshake is nil for other key types.
+func (c *config) generateTLSConfigs(privKey ic.PrivKey) (tlsConf, specTLSConf *tls.Config, err error) {
+	if !c.libp2pTLS || privKey.Type() == pb.KeyType_RSA {
+		tlsConf, err = generateConfig(privKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConf.KeyLogWriter = c.keyLogWriter
+	}
+	if c.libp2pTLS {
+		specTLSConf, err = generateSpecConfig(privKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		specTLSConf.KeyLogWriter = c.keyLogWriter
+	}
+	return tlsConf, specTLSConf, nil
+}
+
+// generateSpecConfig generates a TLS config following the libp2p TLS specification.
+// The certificate is self-signed using an ephemeral key, and the host key is transmitted,
+// together with a signature of the ephemeral public key, in the libp2p Public Key Extension.
+func generateSpecConfig(privKey ic.PrivKey) (*tls.Config, error) {
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := ic.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	certKeyPub, err := x509.MarshalPKIXPublicKey(certKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	signature, err := privKey.Sign(append([]byte(certificatePrefix), certKeyPub...))
+	if err != nil {
+		return nil, err
+	}
+	value, err := asn1.Marshal(signedKey{PubKey: keyBytes, Signature: signature})
+	if err != nil {
+		return nil, err
+	}
+	sn, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    sn,
+		NotBefore:       time.Now().Add(-24 * time.Hour),
+		NotAfter:        time.Now().Add(certValidityPeriod),
+		ExtraExtensions: []pkix.Extension{{Id: extensionID, Value: value}},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, certKey.Public(), certKey)
+	if err != nil {
+		return nil, err
+	}
+	var sessionTicketKey [32]byte
+	if _, err := rand.Read(sessionTicketKey[:]); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true, // This is not insecure here. We will verify the cert chain ourselves.
+		ClientAuth:         tls.RequireAnyClientCert,
+		NextProtos:         []string{alpn},
+		SessionTicketKey:   sessionTicketKey,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{certDER},
+			PrivateKey:  certKey,
+		}},
+	}, nil
+}
+
+// verifyRemoteChain verifies the certificate chain, using the handshake selected by the negotiated protocol,
+// and returns the public key of the remote peer.
+func verifyRemoteChain(negotiatedProtocol string, chain []*x509.Certificate, ignoreTimeValidity bool) (ic.PubKey, error) {
+	if negotiatedProtocol == alpn {
+		return getSpecRemotePubKey(chain, ignoreTimeValidity)
+	}
+	return getRemotePubKey(chain, ignoreTimeValidity)
+}
+
+// getSpecRemotePubKey verifies a certificate chain following the libp2p TLS specification,
+// and returns the public key of the remote peer.
+func getSpecRemotePubKey(chain []*x509.Certificate, ignoreTimeValidity bool) (ic.PubKey, error) {
+	if len(chain) != 1 {
+		return nil, errors.New("expected one certificate in the chain")
+	}
+	cert := chain[0]
+	// The libp2p Public Key Extension may be marked critical. It is handled below.
+	var unhandled []asn1.ObjectIdentifier
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(extensionID) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	cert.UnhandledCriticalExtensions = unhandled
+	if ignoreTimeValidity {
+		// The certificate is self-signed, but not a CA certificate, so CheckSignatureFrom can't be used.
+		if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+			return nil, err
+		}
+	} else {
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+			return nil, err
+		}
+	}
+	var value []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(extensionID) {
+			value = ext.Value
+			break
+		}
+	}
+	if value == nil {
+		return nil, errors.New("expected certificate to contain the libp2p Public Key Extension")
+	}
+	var sk signedKey
+	if rest, err := asn1.Unmarshal(value, &sk); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after the libp2p Public Key Extension")
+	}
+	pubKey, err := ic.UnmarshalPublicKey(sk.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := pubKey.Verify(append([]byte(certificatePrefix), cert.RawSubjectPublicKeyInfo...), sk.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errors.New("invalid signature of the certificate's public key")
+	}
+	return pubKey, nil
+}
+
+// getRemotePubKey verifies the certificate chain, and returns the public key of the remote peer.
+// If ignoreTimeValidity is set, the NotBefore and NotAfter fields of the certificates are not checked.
+// This is safe, since the certificates are self-signed, and the trust is anchored in the key, not in the time.
+func getRemotePubKey(chain []*x509.Certificate, ignoreTimeValidity bool) (ic.PubKey, error) {
 	if len(chain) != 2 {
 		return nil, errors.New("expected 2 certificates in the chain")
 	}
-	pool := x509.NewCertPool()
-	pool.AddCert(chain[1])
-	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: pool}); err != nil {
-		return nil, err
+	if ignoreTimeValidity {
+		if err := chain[0].CheckSignatureFrom(chain[1]); err != nil {
+			return nil, err
+		}
+	} else {
+		pool := x509.NewCertPool()
+		pool.AddCert(chain[1])
+		if _, err := chain[0].Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+			return nil, err
+		}
 	}
 	remotePubKey, err := x509.MarshalPKIXPublicKey(chain[1].PublicKey)
 	if err != nil {
@@ -0,0 +1,168 @@
+package libp2pquic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// createExpiredCertChain creates a certificate chain for the key that expired a year ago.
+func createExpiredCertChain(key *rsa.PrivateKey) tls.Certificate {
+	hostTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-2 * 365 * 24 * time.Hour),
+		NotAfter:              time.Now().Add(-365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	hostCertDER, err := x509.CreateCertificate(rand.Reader, hostTmpl, hostTmpl, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	hostCert, err := x509.ParseCertificate(hostCertDER)
+	Expect(err).ToNot(HaveOccurred())
+	ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	certTmpl := &x509.Certificate{
+		DNSNames:     []string{hostname},
+		SerialNumber: big.NewInt(1),
+		NotBefore:    hostTmpl.NotBefore,
+		NotAfter:     hostTmpl.NotAfter,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, certTmpl, hostCert, ephemeralKey.Public(), key)
+	Expect(err).ToNot(HaveOccurred())
+	return tls.Certificate{
+		Certificate: [][]byte{certDER, hostCertDER},
+		PrivateKey:  ephemeralKey,
+	}
+}
+
+var _ = Describe("Crypto", func() {
+	var (
+		rsaKey *rsa.PrivateKey
+		key    ic.PrivKey
+	)
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err = ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	parseChain := func(cert tls.Certificate) []*x509.Certificate {
+		chain := make([]*x509.Certificate, len(cert.Certificate))
+		for i, der := range cert.Certificate {
+			c, err := x509.ParseCertificate(der)
+			Expect(err).ToNot(HaveOccurred())
+			chain[i] = c
+		}
+		return chain
+	}
+
+	It("gets the public key from a certificate chain", func() {
+		tlsConf, err := generateConfig(key)
+		Expect(err).ToNot(HaveOccurred())
+		pubKey, err := getRemotePubKey(parseChain(tlsConf.Certificates[0]), false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey).To(Equal(key.GetPublic()))
+	})
+
+	It("rejects expired certificates", func() {
+		_, err := getRemotePubKey(parseChain(createExpiredCertChain(rsaKey)), false)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("accepts expired certificates, when ignoring the validity period", func() {
+		pubKey, err := getRemotePubKey(parseChain(createExpiredCertChain(rsaKey)), true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pubKey).To(Equal(key.GetPublic()))
+	})
+
+	It("rejects invalid signatures, when ignoring the validity period", func() {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		chain := parseChain(createExpiredCertChain(rsaKey))
+		chain[1] = parseChain(createExpiredCertChain(otherKey))[1]
+		_, err = getRemotePubKey(chain, true)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("libp2p TLS specification", func() {
+		It("gets the public key from a certificate", func() {
+			tlsConf, err := generateSpecConfig(key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tlsConf.NextProtos).To(Equal([]string{"libp2p"}))
+			pubKey, err := getSpecRemotePubKey(parseChain(tlsConf.Certificates[0]), false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pubKey).To(Equal(key.GetPublic()))
+		})
+
+		It("gets the public key from a certificate, when ignoring the validity period", func() {
+			tlsConf, err := generateSpecConfig(key)
+			Expect(err).ToNot(HaveOccurred())
+			pubKey, err := getSpecRemotePubKey(parseChain(tlsConf.Certificates[0]), true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pubKey).To(Equal(key.GetPublic()))
+		})
+
+		It("selects the handshake using the negotiated protocol", func() {
+			tlsConf, err := generateSpecConfig(key)
+			Expect(err).ToNot(HaveOccurred())
+			pubKey, err := verifyRemoteChain("libp2p", parseChain(tlsConf.Certificates[0]), false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pubKey).To(Equal(key.GetPublic()))
+			_, err = verifyRemoteChain("", parseChain(tlsConf.Certificates[0]), false)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects certificates without the libp2p Public Key Extension", func() {
+			tlsConf, err := generateConfig(key)
+			Expect(err).ToNot(HaveOccurred())
+			chain := parseChain(tlsConf.Certificates[0])
+			_, err = getSpecRemotePubKey(chain[1:], false)
+			Expect(err).To(MatchError("expected certificate to contain the libp2p Public Key Extension"))
+		})
+
+		It("rejects certificates whose public key wasn't signed by the host key", func() {
+			otherKey, _, err := ic.GenerateRSAKeyPair(1024, rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			tlsConf, err := generateSpecConfig(key)
+			Expect(err).ToNot(HaveOccurred())
+			otherTLSConf, err := generateSpecConfig(otherKey)
+			Expect(err).ToNot(HaveOccurred())
+			// use the signed key of the other certificate
+			cert := parseChain(tlsConf.Certificates[0])[0]
+			otherCert := parseChain(otherTLSConf.Certificates[0])[0]
+			var extensions []pkix.Extension
+			for _, ext := range otherCert.Extensions {
+				if ext.Id.Equal(extensionID) {
+					extensions = append(extensions, ext)
+				}
+			}
+			tmpl := &x509.Certificate{
+				SerialNumber:    big.NewInt(1),
+				NotBefore:       cert.NotBefore,
+				NotAfter:        cert.NotAfter,
+				ExtraExtensions: extensions,
+			}
+			certKey := tlsConf.Certificates[0].PrivateKey.(*ecdsa.PrivateKey)
+			certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, certKey.Public(), certKey)
+			Expect(err).ToNot(HaveOccurred())
+			forged, err := x509.ParseCertificate(certDER)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = getSpecRemotePubKey([]*x509.Certificate{forged}, false)
+			Expect(err).To(MatchError("invalid signature of the certificate's public key"))
+		})
+	})
+})
@@ -0,0 +1,89 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// The causes of failed dials. Dial returns a *DialError, which matches the cause using errors.Is.
+var (
+	// ErrPeerIDMismatch is the cause of a dial failure if the peer presented a valid certificate,
+	// but for a different peer ID than the one dialed.
+	ErrPeerIDMismatch = errors.New("peer IDs don't match")
+	// ErrHandshakeTimeout is the cause of a dial failure if the handshake didn't complete in time,
+	// e.g. because the peer is unreachable.
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+	// ErrConnectionRefused is the cause of a dial failure if the peer closed the connection during the handshake,
+	// or if the peer's host refused the packets.
+	ErrConnectionRefused = errors.New("connection refused")
+	// ErrVersionNegotiation is the cause of a dial failure if the peer doesn't support any of our QUIC versions.
+	ErrVersionNegotiation = errors.New("version negotiation failed")
+	// ErrSocket is the cause of a dial failure if creating the socket, or sending or receiving on it failed.
+	ErrSocket = errors.New("socket error")
+)
+
+// A DialError is returned by Dial when a dial fails for one of the causes above.
+// Errors that are returned as is, like ErrGated, ErrDialToSelf or the error of the context, are not wrapped.
+type DialError struct {
+	Addr ma.Multiaddr
+	Peer peer.ID
+	// Cause is one of ErrPeerIDMismatch, ErrHandshakeTimeout, ErrConnectionRefused, ErrVersionNegotiation and ErrSocket.
+	Cause error
+	// Err is the underlying error, usually returned by quic-go or the net package.
+	Err error
+}
+
+func (e *DialError) Error() string {
+	if e.Err == e.Cause {
+		return e.Cause.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Cause, e.Err)
+}
+
+// Is says if target is the cause of the error.
+func (e *DialError) Is(target error) bool {
+	return target == e.Cause
+}
+
+// Unwrap returns the underlying error.
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// newDialError wraps the error of a failed dial in a DialError, if its cause can be determined.
+// verifyErr is the error returned when verifying the peer's certificate chain, if any.
+func newDialError(raddr ma.Multiaddr, p peer.ID, err, verifyErr error) error {
+	var cause error
+	var opErr *net.OpError
+	// the errors of quic-go sessions, the type is internal to quic-go
+	var qErr interface {
+		net.Error
+		IsCryptoError() bool
+	}
+	switch {
+	case verifyErr == ErrPeerIDMismatch || err == ErrPeerIDMismatch:
+		cause = ErrPeerIDMismatch
+	case errors.Is(err, syscall.ECONNREFUSED):
+		cause = ErrConnectionRefused
+	case errors.As(err, &opErr):
+		cause = ErrSocket
+	case strings.HasPrefix(err.Error(), "No compatible QUIC version found"):
+		// quic-go doesn't define an error type for failed version negotiations
+		cause = ErrVersionNegotiation
+	case errors.As(err, &qErr) && qErr.Timeout():
+		cause = ErrHandshakeTimeout
+	case errors.As(err, &qErr) && verifyErr == nil:
+		// the peer closed the connection, our handshake didn't fail
+		cause = ErrConnectionRefused
+	default:
+		return err
+	}
+	return &DialError{Addr: raddr, Peer: p, Cause: cause, Err: err}
+}
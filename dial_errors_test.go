@@ -0,0 +1,69 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockQUICError mocks the errors of quic-go sessions
+type mockQUICError struct {
+	timeout bool
+}
+
+func (e *mockQUICError) Error() string       { return "quic error" }
+func (e *mockQUICError) Timeout() bool       { return e.timeout }
+func (e *mockQUICError) Temporary() bool     { return false }
+func (e *mockQUICError) IsCryptoError() bool { return false }
+
+var _ = Describe("Dial errors", func() {
+	raddr := ma.StringCast("/ip4/127.0.0.1/udp/1234/quic")
+
+	It("determines the cause", func() {
+		for _, t := range []struct {
+			err, verifyErr, cause error
+		}{
+			{err: &mockQUICError{}, verifyErr: ErrPeerIDMismatch, cause: ErrPeerIDMismatch},
+			{err: ErrPeerIDMismatch, cause: ErrPeerIDMismatch},
+			{err: &mockQUICError{timeout: true}, cause: ErrHandshakeTimeout},
+			{err: &mockQUICError{}, cause: ErrConnectionRefused},
+			{err: &net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ECONNREFUSED)}, cause: ErrConnectionRefused},
+			{err: &net.OpError{Op: "listen", Err: os.NewSyscallError("socket", syscall.EMFILE)}, cause: ErrSocket},
+			{err: errors.New("No compatible QUIC version found. We support [0xff000016], server offered [0x1]"), cause: ErrVersionNegotiation},
+		} {
+			err := newDialError(raddr, "peer", t.err, t.verifyErr)
+			Expect(errors.Is(err, t.cause)).To(BeTrue(), fmt.Sprintf("%s should be caused by %s", t.err, t.cause))
+			var dialErr *DialError
+			Expect(errors.As(err, &dialErr)).To(BeTrue())
+			Expect(dialErr.Addr).To(Equal(raddr))
+			Expect(errors.Unwrap(err)).To(Equal(t.err))
+		}
+	})
+
+	It("doesn't wrap errors with an unknown cause", func() {
+		// certificate verification failed
+		err := &mockQUICError{}
+		Expect(newDialError(raddr, "peer", err, errors.New("invalid certificate"))).To(Equal(err))
+		Expect(newDialError(raddr, "peer", ErrTransportClosed, nil)).To(Equal(ErrTransportClosed))
+	})
+
+	It("matches the errors of all dials, when dialing multiple addresses", func() {
+		addrs := []ma.Multiaddr{raddr, ma.StringCast("/ip6/::1/udp/1234/quic")}
+		err := combineDialErrors(addrs, []error{
+			newDialError(addrs[0], "peer", &mockQUICError{timeout: true}, nil),
+			newDialError(addrs[1], "peer", &net.OpError{Op: "listen", Err: syscall.EAFNOSUPPORT}, nil),
+		})
+		Expect(errors.Is(err, ErrHandshakeTimeout)).To(BeTrue())
+		Expect(errors.Is(err, ErrSocket)).To(BeTrue())
+		Expect(errors.Is(err, ErrVersionNegotiation)).To(BeFalse())
+		var opErr *net.OpError
+		Expect(errors.As(err, &opErr)).To(BeTrue())
+	})
+})
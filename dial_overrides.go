@@ -0,0 +1,45 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/tls"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+type quicConfigOverrideKey struct{}
+
+type tlsConfigOverrideKey struct{}
+
+// WithQUICConfigOverride returns a context that modifies the QUIC configuration of dials using this context.
+// The override is applied to a copy of the transport's configuration, for this dial only.
+// Since dials share a socket, it must not change the ConnectionIDLength or the StatelessResetKey.
+func WithQUICConfigOverride(ctx context.Context, override func(*quic.Config)) context.Context {
+	return context.WithValue(ctx, quicConfigOverrideKey{}, override)
+}
+
+// WithTLSConfigOverride returns a context that modifies the TLS configuration of dials using this context.
+// The override is applied to a copy of the transport's configuration, for this dial only.
+// The verification of the peer's certificate can't be overridden,
+// VerifyPeerCertificate is always replaced by the transport.
+func WithTLSConfigOverride(ctx context.Context, override func(*tls.Config)) context.Context {
+	return context.WithValue(ctx, tlsConfigOverrideKey{}, override)
+}
+
+// dialQUICConfig returns the QUIC configuration for a dial using ctx.
+func (c *config) dialQUICConfig(ctx context.Context) *quic.Config {
+	override, ok := ctx.Value(quicConfigOverrideKey{}).(func(*quic.Config))
+	if !ok {
+		return c.quicConfig
+	}
+	conf := *c.quicConfig
+	override(&conf)
+	return &conf
+}
+
+// overrideTLSConfig applies the TLS config override of ctx, if any, to tlsConf.
+func overrideTLSConfig(ctx context.Context, tlsConf *tls.Config) {
+	if override, ok := ctx.Value(tlsConfigOverrideKey{}).(func(*tls.Config)); ok {
+		override(tlsConf)
+	}
+}
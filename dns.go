@@ -0,0 +1,120 @@
+package libp2pquic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/whyrusleeping/mafmt"
+)
+
+// The multiaddr protocols of DNS names.
+// They are not defined by go-multiaddr, but registered by go-multiaddr-dns.
+// Without it, DNS multiaddrs can't be parsed, so they never reach the transport.
+const (
+	protoDNS4    = 0x36
+	protoDNS6    = 0x37
+	protoDNSAddr = 0x38
+)
+
+// maxDNSAddrDepth is the maximum depth of nested /dnsaddr records.
+const maxDNSAddrDepth = 4
+
+var (
+	dnsQUIC = mafmt.And(mafmt.Or(mafmt.Base(protoDNS4), mafmt.Base(protoDNS6)), mafmt.Base(ma.P_UDP), mafmt.Base(ma.P_QUIC))
+	dnsAddr = mafmt.Or(mafmt.Base(protoDNSAddr), mafmt.And(mafmt.Base(protoDNSAddr), mafmt.Base(ma.P_P2P)))
+)
+
+var (
+	lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	lookupTXT    = net.DefaultResolver.LookupTXT
+)
+
+// isDNSMultiaddr says if addr needs to be resolved before dialing.
+func isDNSMultiaddr(addr ma.Multiaddr) bool {
+	return dnsQUIC.Matches(addr) || dnsAddr.Matches(addr)
+}
+
+// resolve resolves a /dns4, /dns6 or /dnsaddr multiaddr to the QUIC multiaddrs of peer p.
+func resolve(ctx context.Context, addr ma.Multiaddr, p peer.ID) ([]ma.Multiaddr, error) {
+	addrs, err := resolveDepth(ctx, addr, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s didn't resolve to any QUIC addresses", addr)
+	}
+	return addrs, nil
+}
+
+func resolveDepth(ctx context.Context, addr ma.Multiaddr, p peer.ID, depth int) ([]ma.Multiaddr, error) {
+	first, rest := ma.SplitFirst(addr)
+	switch first.Protocol().Code {
+	case protoDNS4, protoDNS6:
+		ipProto := "ip4"
+		if first.Protocol().Code == protoDNS6 {
+			ipProto = "ip6"
+		}
+		ips, err := lookupIPAddr(ctx, first.Value())
+		if err != nil {
+			return nil, err
+		}
+		var addrs []ma.Multiaddr
+		for _, ip := range ips {
+			if (ip.IP.To4() != nil) != (ipProto == "ip4") {
+				continue
+			}
+			ipAddr, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s", ipProto, ip.IP))
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, ipAddr.Encapsulate(rest))
+		}
+		return addrs, nil
+	case protoDNSAddr:
+		if depth >= maxDNSAddrDepth {
+			return nil, fmt.Errorf("too many nested /dnsaddr records resolving %s", addr)
+		}
+		records, err := lookupTXT(ctx, "_dnsaddr."+first.Value())
+		if err != nil {
+			return nil, err
+		}
+		var addrs []ma.Multiaddr
+		for _, record := range records {
+			if !strings.HasPrefix(record, "dnsaddr=") {
+				continue
+			}
+			recordAddr, err := ma.NewMultiaddr(strings.TrimPrefix(record, "dnsaddr="))
+			if err != nil {
+				continue
+			}
+			// records for other peers are ignored
+			if id, err := recordAddr.ValueForProtocol(ma.P_P2P); err == nil {
+				if id != p.Pretty() {
+					continue
+				}
+				p2p, err := ma.NewMultiaddr("/" + ma.ProtocolWithCode(ma.P_P2P).Name + "/" + id)
+				if err != nil {
+					continue
+				}
+				recordAddr = recordAddr.Decapsulate(p2p)
+			}
+			if isDNSMultiaddr(recordAddr) {
+				resolved, err := resolveDepth(ctx, recordAddr, p, depth+1)
+				if err != nil {
+					continue
+				}
+				addrs = append(addrs, resolved...)
+			} else if mafmt.QUIC.Matches(recordAddr) {
+				addrs = append(addrs, recordAddr)
+			}
+		}
+		return addrs, nil
+	default:
+		return []ma.Multiaddr{addr}, nil
+	}
+}
@@ -0,0 +1,116 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The DNS protocols are registered by go-multiaddr-dns, which isn't a dependency of this package.
+func init() {
+	transcoder := ma.NewTranscoderFromFunctions(
+		func(s string) ([]byte, error) { return []byte(s), nil },
+		func(b []byte) (string, error) { return string(b), nil },
+		nil,
+	)
+	for code, name := range map[int]string{protoDNS4: "dns4", protoDNS6: "dns6", protoDNSAddr: "dnsaddr"} {
+		if ma.ProtocolWithCode(code).Code != 0 {
+			continue
+		}
+		if err := ma.AddProtocol(ma.Protocol{
+			Name:       name,
+			Code:       code,
+			VCode:      ma.CodeToVarint(code),
+			Size:       ma.LengthPrefixedVarSize,
+			Transcoder: transcoder,
+		}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+var _ = Describe("DNS", func() {
+	var origLookupIPAddr func(context.Context, string) ([]net.IPAddr, error)
+	var origLookupTXT func(context.Context, string) ([]string, error)
+
+	BeforeEach(func() {
+		origLookupIPAddr, origLookupTXT = lookupIPAddr, lookupTXT
+		lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+			if host != "example.com" {
+				return nil, errors.New("no such host")
+			}
+			return []net.IPAddr{{IP: net.ParseIP("1.2.3.4")}, {IP: net.ParseIP("::1")}, {IP: net.ParseIP("5.6.7.8")}}, nil
+		}
+	})
+
+	AfterEach(func() {
+		lookupIPAddr, lookupTXT = origLookupIPAddr, origLookupTXT
+	})
+
+	It("says which multiaddrs need to be resolved", func() {
+		Expect(isDNSMultiaddr(ma.StringCast("/dns4/example.com/udp/1234/quic"))).To(BeTrue())
+		Expect(isDNSMultiaddr(ma.StringCast("/dns6/example.com/udp/1234/quic"))).To(BeTrue())
+		Expect(isDNSMultiaddr(ma.StringCast("/dnsaddr/example.com"))).To(BeTrue())
+		Expect(isDNSMultiaddr(ma.StringCast("/dns4/example.com/tcp/1234"))).To(BeFalse())
+		Expect(isDNSMultiaddr(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"))).To(BeFalse())
+	})
+
+	It("resolves /dns4 and /dns6 multiaddrs", func() {
+		addrs, err := resolve(context.Background(), ma.StringCast("/dns4/example.com/udp/1234/quic"), "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(addrs).To(Equal([]ma.Multiaddr{
+			ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"),
+			ma.StringCast("/ip4/5.6.7.8/udp/1234/quic"),
+		}))
+		addrs, err = resolve(context.Background(), ma.StringCast("/dns6/example.com/udp/1234/quic"), "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(addrs).To(Equal([]ma.Multiaddr{ma.StringCast("/ip6/::1/udp/1234/quic")}))
+	})
+
+	It("resolves /dnsaddr multiaddrs", func() {
+		id, err := peer.IDB58Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSoooo4")
+		Expect(err).ToNot(HaveOccurred())
+		otherID, err := peer.IDB58Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+		Expect(err).ToNot(HaveOccurred())
+		lookupTXT = func(_ context.Context, name string) ([]string, error) {
+			switch name {
+			case "_dnsaddr.bootstrap.example.com":
+				return []string{
+					fmt.Sprintf("dnsaddr=/dnsaddr/nested.example.com/p2p/%s", id.Pretty()),
+					fmt.Sprintf("dnsaddr=/ip4/9.9.9.9/udp/1234/quic/p2p/%s", otherID.Pretty()),
+					fmt.Sprintf("dnsaddr=/ip4/9.9.9.9/tcp/1234/p2p/%s", id.Pretty()),
+					"foobar",
+				}, nil
+			case "_dnsaddr.nested.example.com":
+				return []string{
+					fmt.Sprintf("dnsaddr=/dns4/example.com/udp/1234/quic/p2p/%s", id.Pretty()),
+					"dnsaddr=/ip4/4.3.2.1/udp/4321/quic",
+				}, nil
+			default:
+				return nil, errors.New("no such host")
+			}
+		}
+		addrs, err := resolve(context.Background(), ma.StringCast("/dnsaddr/bootstrap.example.com"), id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(addrs).To(Equal([]ma.Multiaddr{
+			ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"),
+			ma.StringCast("/ip4/5.6.7.8/udp/1234/quic"),
+			ma.StringCast("/ip4/4.3.2.1/udp/4321/quic"),
+		}))
+	})
+
+	It("errors if no QUIC addresses are found", func() {
+		lookupTXT = func(context.Context, string) ([]string, error) {
+			return []string{"dnsaddr=/ip4/1.2.3.4/tcp/1234"}, nil
+		}
+		_, err := resolve(context.Background(), ma.StringCast("/dnsaddr/example.com"), "")
+		Expect(err).To(MatchError("/dnsaddr/example.com didn't resolve to any QUIC addresses"))
+	})
+})
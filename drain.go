@@ -0,0 +1,82 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errListenerDraining = errors.New("listener draining")
+
+// Drain closes the listener gracefully.
+// New handshakes are rejected, Accept returns an error, and the socket is no longer used for new dials.
+// Drain then waits until all connections using the listener's socket are closed, both accepted and dialed ones.
+// Once they are, or when ctx is done, the listener is closed, closing the remaining connections, and the socket is closed.
+// If ctx is done before all connections are closed, ctx's error is returned.
+func (l *listener) Drain(ctx context.Context) error {
+	l.stopAccepting()
+	l.transport.connManager.removeListenConn(l.conn)
+	l.config.debugw("draining listener", "addr", l.localMultiaddr)
+	err := l.waitForConns(ctx)
+	if cerr := l.Close(); err == nil {
+		err = cerr
+	}
+	l.closeSockets()
+	if err != nil {
+		l.config.debugw("draining listener failed", "addr", l.localMultiaddr, "error", err)
+	}
+	return err
+}
+
+// waitForConns waits until the connections using the listener's socket are closed.
+// Connections established while waiting, by handshakes or dials that were already running, are also waited for.
+func (l *listener) waitForConns(ctx context.Context) error {
+	for {
+		var conns []*conn
+		for _, c := range l.transport.conns.all() {
+			if c.localMultiaddr.Equal(l.localMultiaddr) && c.sess.Context().Err() == nil {
+				conns = append(conns, c)
+			}
+		}
+		if len(conns) == 0 {
+			return nil
+		}
+		for _, c := range conns {
+			select {
+			case <-c.sess.Context().Done():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (l *listener) closeSockets() {
+	l.closeSocketsOnce.Do(func() {
+		for _, conn := range l.sockets {
+			conn.Close()
+		}
+	})
+}
+
+// Drain drains the listeners of all addresses concurrently.
+// The error of the first listener that fails to drain is returned.
+func (l *multiListener) Drain(ctx context.Context) error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	errs := make([]error, len(l.listeners))
+	var wg sync.WaitGroup
+	for i, ln := range l.listeners {
+		wg.Add(1)
+		go func(i int, ln Listener) {
+			defer wg.Done()
+			errs[i] = ln.Drain(ctx)
+		}(i, ln)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package libp2pquic
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrGated is returned by Dial when the connection was rejected by the ConnectionGater.
+var ErrGated = errors.New("connection rejected by the connection gater")
+
+// A ConnectionGater decides which connections are established.
+// The methods have the same signatures as those of the connmgr.ConnectionGater of later versions of go-libp2p-core,
+// so that gaters implementing that interface can be used.
+type ConnectionGater interface {
+	// InterceptAddrDial is called before dialing a peer at an address.
+	// If it returns false, the dial fails with ErrGated.
+	InterceptAddrDial(peer.ID, ma.Multiaddr) bool
+	// InterceptAccept is called when a listener receives a new connection, before the handshake.
+	// If it returns false, the handshake is aborted.
+	InterceptAccept(network.ConnMultiaddrs) bool
+	// InterceptSecured is called after the handshake, once the peer was authenticated.
+	// If it returns false, the connection is closed with ErrorCodeGated.
+	InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool
+}
+
+// connMultiaddrs are the multiaddrs of a connection that hasn't been established yet.
+type connMultiaddrs struct {
+	local, remote ma.Multiaddr
+}
+
+var _ network.ConnMultiaddrs = &connMultiaddrs{}
+
+func (c *connMultiaddrs) LocalMultiaddr() ma.Multiaddr  { return c.local }
+func (c *connMultiaddrs) RemoteMultiaddr() ma.Multiaddr { return c.remote }
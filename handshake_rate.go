@@ -0,0 +1,78 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var errHandshakeRateLimited = errors.New("handshake rate limit exceeded")
+
+// handshakeRatePruneInterval is the interval at which the limiters of idle prefixes are removed.
+const handshakeRatePruneInterval = time.Minute
+
+// handshakeRateLimiter limits the rate of handshakes accepted from every source prefix.
+type handshakeRateLimiter struct {
+	limit              rate.Limit
+	burst              int
+	ipv4Mask, ipv6Mask net.IPMask
+
+	mutex     sync.Mutex
+	limiters  map[string]*handshakeLimiter
+	lastPrune time.Time
+}
+
+type handshakeLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+func newHandshakeRateLimiter(handshakesPerSecond float64, burst, ipv4PrefixLen, ipv6PrefixLen int) *handshakeRateLimiter {
+	return &handshakeRateLimiter{
+		limit:     rate.Limit(handshakesPerSecond),
+		burst:     burst,
+		ipv4Mask:  net.CIDRMask(ipv4PrefixLen, 8*net.IPv4len),
+		ipv6Mask:  net.CIDRMask(ipv6PrefixLen, 8*net.IPv6len),
+		limiters:  make(map[string]*handshakeLimiter),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow says if a handshake from ip is allowed, and counts it.
+func (l *handshakeRateLimiter) allow(ip net.IP) bool {
+	var prefix net.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix = ip4.Mask(l.ipv4Mask)
+	} else {
+		prefix = ip.Mask(l.ipv6Mask)
+	}
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.lastPrune) > handshakeRatePruneInterval {
+		l.prune(now)
+	}
+	key := prefix.String()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = &handshakeLimiter{Limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = limiter
+	}
+	limiter.lastUsed = now
+	return limiter.AllowN(now, 1)
+}
+
+// prune removes the limiters of prefixes that were idle long enough to refill the burst.
+// A new limiter behaves the same way.
+func (l *handshakeRateLimiter) prune(now time.Time) {
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, limiter := range l.limiters {
+		if now.Sub(limiter.lastUsed) > refill {
+			delete(l.limiters, key)
+		}
+	}
+	l.lastPrune = now
+}
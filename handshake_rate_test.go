@@ -0,0 +1,33 @@
+package libp2pquic
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handshake rate limit", func() {
+	It("limits the handshake rate per source prefix", func() {
+		conf, err := newConfig(WithHandshakeRateLimit(0.001, 2, 24, 64))
+		Expect(err).ToNot(HaveOccurred())
+		l := conf.handshakeRateLimiter
+		// the first 2 handshakes use the burst
+		Expect(l.allow(net.ParseIP("192.168.1.1"))).To(BeTrue())
+		Expect(l.allow(net.ParseIP("192.168.1.2"))).To(BeTrue())
+		Expect(l.allow(net.ParseIP("192.168.1.3"))).To(BeFalse())
+		// other prefixes are limited independently
+		Expect(l.allow(net.ParseIP("192.168.2.1"))).To(BeTrue())
+		Expect(l.allow(net.ParseIP("2001:db8::1"))).To(BeTrue())
+		Expect(l.allow(net.ParseIP("2001:db8::2"))).To(BeTrue())
+		Expect(l.allow(net.ParseIP("2001:db8::3"))).To(BeFalse())
+		Expect(l.allow(net.ParseIP("2001:db8:0:1::1"))).To(BeTrue())
+	})
+
+	It("rejects invalid prefix lengths", func() {
+		_, err := newConfig(WithHandshakeRateLimit(1, 1, 33, 64))
+		Expect(err).To(MatchError("invalid prefix length"))
+		_, err = newConfig(WithHandshakeRateLimit(1, 1, 24, 129))
+		Expect(err).To(MatchError("invalid prefix length"))
+	})
+})
@@ -0,0 +1,115 @@
+package libp2pquic
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// A HandshakeRecord summarizes a handshake.
+// It doesn't contain any secret key material, and can safely be serialized and exported.
+type HandshakeRecord struct {
+	Direction       network.Direction
+	LocalMultiaddr  ma.Multiaddr
+	RemoteMultiaddr ma.Multiaddr
+	// RemotePeer is the peer that was dialed, for outgoing connections.
+	// For incoming connections, it is the peer that connected, and only set if the handshake succeeded.
+	RemotePeer peer.ID
+
+	// Start is the time the dial was started, and Duration the time it took to complete.
+	// They are only set for outgoing connections: listeners only learn about handshakes once they completed.
+	Start    time.Time
+	Duration time.Duration
+
+	// The following fields describe the negotiated TLS parameters, and are only set if the handshake succeeded.
+	TLSVersion         uint16
+	CipherSuite        uint16
+	NegotiatedProtocol string
+	DidResume          bool
+	// PeerCertificateFingerprints are the SHA-256 hashes of the DER encoded certificates presented by the peer.
+	PeerCertificateFingerprints [][sha256.Size]byte
+
+	// Timings are the durations of the handshake phases. They are only set for outgoing connections.
+	Timings HandshakeTimings
+
+	// Error is the error that occurred, or nil if the handshake succeeded.
+	Error error
+}
+
+// HandshakeTimings are the durations of the phases of a dial.
+// The phases are contiguous, so their durations add up to Total.
+// quic-go doesn't report when the first Initial packet is sent, or when the handshake keys become available.
+// The time from sending the first Initial until receiving the server's certificate is therefore reported as a single phase.
+// If the dial failed, phases that were never reached are 0.
+type HandshakeTimings struct {
+	// SocketAcquisition is the time it took to get the UDP socket used for dialing.
+	// It is only significant if the socket had to be created, since sockets are shared between dials.
+	SocketAcquisition time.Duration
+	// ServerFlight ends when the server's certificate is received.
+	// It includes the first round trip, and the derivation of the handshake keys on both sides.
+	ServerFlight time.Duration
+	// Confirmation ends when the handshake completes.
+	// It includes the verification of the server's certificate.
+	Confirmation time.Duration
+	// Total is the duration of the entire dial.
+	Total time.Duration
+}
+
+// handshakeTimer records the times at which the phases of a dial end.
+type handshakeTimer struct {
+	start                time.Time
+	socketAcquired       time.Time
+	serverFlightReceived time.Time
+	done                 time.Time
+}
+
+func (t *handshakeTimer) timings() HandshakeTimings {
+	var timings HandshakeTimings
+	last := t.start
+	for _, phase := range []struct {
+		end time.Time
+		d   *time.Duration
+	}{
+		{t.socketAcquired, &timings.SocketAcquisition},
+		{t.serverFlightReceived, &timings.ServerFlight},
+		{t.done, &timings.Confirmation},
+	} {
+		if phase.end.IsZero() {
+			continue
+		}
+		*phase.d = phase.end.Sub(last)
+		last = phase.end
+	}
+	timings.Total = last.Sub(t.start)
+	return timings
+}
+
+func newHandshakeRecord(dir network.Direction, raddr ma.Multiaddr, p peer.ID, start time.Time, c *conn, err error) HandshakeRecord {
+	r := HandshakeRecord{
+		Direction:       dir,
+		RemoteMultiaddr: raddr,
+		RemotePeer:      p,
+		Start:           start,
+		Error:           err,
+	}
+	if !start.IsZero() {
+		r.Duration = time.Since(start)
+	}
+	if c == nil {
+		return r
+	}
+	state := c.sess.ConnectionState()
+	r.LocalMultiaddr = c.localMultiaddr
+	r.TLSVersion = state.Version
+	r.CipherSuite = state.CipherSuite
+	r.NegotiatedProtocol = state.NegotiatedProtocol
+	r.DidResume = state.DidResume
+	r.PeerCertificateFingerprints = make([][sha256.Size]byte, len(state.PeerCertificates))
+	for i, cert := range state.PeerCertificates {
+		r.PeerCertificateFingerprints[i] = sha256.Sum256(cert.Raw)
+	}
+	return r
+}
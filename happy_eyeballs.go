@@ -0,0 +1,220 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// happyEyeballsDelay is the time after which the next address is dialed, if the previous dial didn't complete yet.
+// This is the Connection Attempt Delay recommended by RFC 8305.
+const happyEyeballsDelay = 250 * time.Millisecond
+
+var errNoAddrs = errors.New("no addresses to dial")
+
+// An AddressFamily is an IP address family, see WithAddressFamilyPreference.
+type AddressFamily uint8
+
+const (
+	// FamilyIPv6 is the IPv6 address family.
+	FamilyIPv6 AddressFamily = iota + 1
+	// FamilyIPv4 is the IPv4 address family.
+	FamilyIPv4
+)
+
+func addressFamily(addr ma.Multiaddr) AddressFamily {
+	if first, _ := ma.SplitFirst(addr); first != nil && first.Protocol().Code == ma.P_IP6 {
+		return FamilyIPv6
+	}
+	return FamilyIPv4
+}
+
+// splitAddressFamily splits the addresses into those of the family, and those of the other family.
+// The order of the addresses is kept.
+func splitAddressFamily(addrs []ma.Multiaddr, family AddressFamily) (preferred, other []ma.Multiaddr) {
+	for _, addr := range addrs {
+		if addressFamily(addr) == family {
+			preferred = append(preferred, addr)
+		} else {
+			other = append(other, addr)
+		}
+	}
+	return preferred, other
+}
+
+// sortHappyEyeballs orders the addresses for dialing: IPv6 and IPv4 addresses are interleaved,
+// starting with an IPv6 address. The order of the addresses of each family is kept.
+func sortHappyEyeballs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	v6, v4 := splitAddressFamily(addrs, FamilyIPv6)
+	sorted := make([]ma.Multiaddr, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return sorted
+}
+
+type dialResult struct {
+	conn    *conn
+	timings handshakeTimer
+	err     error
+}
+
+// dialHappyEyeballs dials the addresses, and returns the first connection established.
+// If an address family is preferred, the addresses of that family are raced first,
+// and the addresses of the other family are only raced if all of them failed.
+func (t *transport) dialHappyEyeballs(ctx context.Context, addrs []ma.Multiaddr, p peer.ID, timings *handshakeTimer) (*conn, error) {
+	if t.config.addressFamily == 0 {
+		return t.raceAddrs(ctx, addrs, p, timings)
+	}
+	preferred, other := splitAddressFamily(addrs, t.config.addressFamily)
+	if len(preferred) == 0 || len(other) == 0 {
+		return t.raceAddrs(ctx, addrs, p, timings)
+	}
+	c, err := t.raceAddrs(ctx, preferred, p, timings)
+	if err == nil || ctx.Err() != nil {
+		return c, err
+	}
+	t.config.debugw("dialing the preferred address family failed", "peer", p, "addrs", preferred, "error", err)
+	*timings = handshakeTimer{start: timings.start}
+	c, otherErr := t.raceAddrs(ctx, other, p, timings)
+	if otherErr != nil {
+		return nil, combineDialErrors(addrs, []error{err, otherErr})
+	}
+	return c, nil
+}
+
+// raceAddrs races the dials of the addresses, as described in RFC 8305.
+// The addresses are dialed in the order of sortHappyEyeballs, the next dial is started when the previous one fails,
+// or after happyEyeballsDelay. The first connection established is returned, the other dials are canceled.
+func (t *transport) raceAddrs(ctx context.Context, addrs []ma.Multiaddr, p peer.ID, timings *handshakeTimer) (*conn, error) {
+	if len(addrs) == 1 {
+		return t.dialAddr(ctx, addrs[0], p, timings)
+	}
+	addrs = sortHappyEyeballs(addrs)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	var next, running int
+	startDial := func() {
+		addr := addrs[next]
+		next++
+		running++
+		go func() {
+			r := dialResult{timings: handshakeTimer{start: timings.start}}
+			r.conn, r.err = t.dialAddr(ctx, addr, p, &r.timings)
+			results <- r
+		}()
+	}
+	// closeRemaining closes the connections of the dials that are still running, if they succeed.
+	closeRemaining := func() {
+		go func(running int) {
+			for i := 0; i < running; i++ {
+				if r := <-results; r.err == nil {
+					r.conn.Close()
+				}
+			}
+		}(running)
+	}
+
+	// failed records the timings of the last dial, and the time the Happy Eyeballs dial failed at.
+	failed := func(r dialResult, err error) (*conn, error) {
+		*timings = r.timings
+		timings.done = time.Now()
+		return nil, err
+	}
+
+	startDial()
+	delay := time.NewTimer(happyEyeballsDelay)
+	defer delay.Stop()
+	var errs []error
+	for {
+		var delayChan <-chan time.Time
+		if next < len(addrs) {
+			delayChan = delay.C
+		}
+		select {
+		case <-delayChan:
+			startDial()
+			delay.Reset(happyEyeballsDelay)
+		case r := <-results:
+			running--
+			if r.err == nil {
+				*timings = r.timings
+				cancel()
+				closeRemaining()
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			if ctx.Err() != nil {
+				closeRemaining()
+				return failed(r, ctx.Err())
+			}
+			if next < len(addrs) {
+				// the dial failed, don't wait for the delay to start the next one
+				if !delay.Stop() {
+					<-delay.C
+				}
+				startDial()
+				delay.Reset(happyEyeballsDelay)
+			} else if running == 0 {
+				return failed(r, combineDialErrors(addrs, errs))
+			}
+		}
+	}
+}
+
+// combineDialErrors returns the error of a failed Happy Eyeballs dial.
+// If all dials failed with the same error, e.g. ErrGated, that error is returned.
+func combineDialErrors(addrs []ma.Multiaddr, errs []error) error {
+	same := true
+	for _, err := range errs[1:] {
+		if err != errs[0] {
+			same = false
+			break
+		}
+	}
+	if same {
+		return errs[0]
+	}
+	return &dialErrors{numAddrs: len(addrs), errs: errs}
+}
+
+// dialErrors are the errors of the dials of multiple addresses.
+// errors.Is and errors.As match any of the errors.
+type dialErrors struct {
+	numAddrs int
+	errs     []error
+}
+
+func (e *dialErrors) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("all dials of %d addresses failed: %s", e.numAddrs, strings.Join(msgs, "; "))
+}
+
+func (e *dialErrors) Unwrap() []error {
+	return e.errs
+}
+
+// DialMany dials the peer on all of the addresses, racing IPv6 and IPv4 dials as described in RFC 8305.
+func (t *transport) DialMany(ctx context.Context, raddrs []ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	if len(raddrs) == 0 {
+		return nil, errNoAddrs
+	}
+	return t.dialCached(ctx, raddrs, p)
+}
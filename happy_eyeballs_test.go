@@ -0,0 +1,81 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Happy Eyeballs", func() {
+	It("interleaves IPv6 and IPv4 addresses, starting with IPv6", func() {
+		addrs := []ma.Multiaddr{
+			ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"),
+			ma.StringCast("/ip4/5.6.7.8/udp/1234/quic"),
+			ma.StringCast("/ip4/9.9.9.9/udp/1234/quic"),
+			ma.StringCast("/ip6/::1/udp/1234/quic"),
+			ma.StringCast("/ip6/::2/udp/1234/quic"),
+		}
+		Expect(sortHappyEyeballs(addrs)).To(Equal([]ma.Multiaddr{
+			ma.StringCast("/ip6/::1/udp/1234/quic"),
+			ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"),
+			ma.StringCast("/ip6/::2/udp/1234/quic"),
+			ma.StringCast("/ip4/5.6.7.8/udp/1234/quic"),
+			ma.StringCast("/ip4/9.9.9.9/udp/1234/quic"),
+		}))
+	})
+
+	It("returns the error, if all dials failed with the same error", func() {
+		addrs := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"), ma.StringCast("/ip6/::1/udp/1234/quic")}
+		Expect(combineDialErrors(addrs, []error{ErrGated, ErrGated})).To(Equal(ErrGated))
+		err := combineDialErrors(addrs, []error{ErrGated, errors.New("timeout")})
+		Expect(err).To(MatchError("all dials of 2 addresses failed: " + ErrGated.Error() + "; timeout"))
+	})
+
+	It("records the time a dial failed at", func() {
+		tr := &transport{config: &config{}}
+		addrs := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"), ma.StringCast("/ip6/::1/udp/1234/quic")}
+		timings := handshakeTimer{start: time.Now()}
+		// dialing our own (empty) peer ID fails immediately
+		_, err := tr.dialHappyEyeballs(context.Background(), addrs, "", &timings)
+		Expect(err).To(MatchError(ErrDialToSelf))
+		Expect(timings.done).ToNot(BeZero())
+		Expect(timings.timings().Total).To(BeNumerically(">", 0))
+	})
+
+	It("dials the preferred address family first, and falls back to the other family", func() {
+		gater := &testGater{rejectDial: true}
+		conf, err := newConfig(WithConnectionGater(gater), WithAddressFamilyPreference(FamilyIPv4))
+		Expect(err).ToNot(HaveOccurred())
+		tr := &transport{config: conf}
+		addrs := []ma.Multiaddr{
+			ma.StringCast("/ip6/::1/udp/1234/quic"),
+			ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"),
+			ma.StringCast("/ip6/::2/udp/1234/quic"),
+		}
+		timings := handshakeTimer{start: time.Now()}
+		_, err = tr.dialHappyEyeballs(context.Background(), addrs, peer.ID("peer"), &timings)
+		Expect(err).To(MatchError(ErrGated))
+		Expect(gater.dials).To(Equal([]ma.Multiaddr{
+			ma.StringCast("/ip4/1.2.3.4/udp/1234/quic"),
+			ma.StringCast("/ip6/::1/udp/1234/quic"),
+			ma.StringCast("/ip6/::2/udp/1234/quic"),
+		}))
+	})
+
+	It("refuses unknown address families", func() {
+		_, err := newConfig(WithAddressFamilyPreference(42))
+		Expect(err).To(MatchError("unknown address family: 42"))
+	})
+
+	It("refuses to dial without addresses", func() {
+		tr := &transport{config: &config{}}
+		_, err := tr.DialMany(context.Background(), nil, "")
+		Expect(err).To(MatchError(errNoAddrs))
+	})
+})
@@ -0,0 +1,298 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// During a hole punch, the server sends packets to the client at holePunchInterval.
+// The interval doubles after every packet, up to holePunchMaxInterval.
+const (
+	holePunchInterval    = 10 * time.Millisecond
+	holePunchMaxInterval = 200 * time.Millisecond
+)
+
+type holePunchKey struct{}
+
+// WithHolePunch returns a context that turns dials using it into a hole punch (simultaneous open).
+// Both peers dial each other at the same time, from the sockets of their listeners,
+// so that the packets sent by each peer open the mappings of its own NAT for the packets of the other peer.
+// The peers must agree on which one of them is the client, e.g. using DCUtR.
+// The client dials a QUIC connection, retransmitting its Initial until it gets through.
+// The server sends packets with random content to the client's address, and waits for the client's connection
+// to be accepted by its listener. Dial returns this connection, it isn't returned by Accept.
+// If the client's connection is accepted before the server started dialing, it is returned by Accept instead.
+// Hole punching requires a listener that accepts connections on the network of the dialed address.
+func WithHolePunch(ctx context.Context, isClient bool) context.Context {
+	return context.WithValue(ctx, holePunchKey{}, isClient)
+}
+
+// holePunchFromContext says if a dial using ctx is a hole punch, and if we are the client.
+func holePunchFromContext(ctx context.Context) (isHolePunch, isClient bool) {
+	isClient, isHolePunch = ctx.Value(holePunchKey{}).(bool)
+	return
+}
+
+// The holePunchRegistry hands accepted connections to the dials of hole punches that are waiting for them.
+type holePunchRegistry struct {
+	mutex   sync.Mutex
+	waiting map[string]chan *conn
+}
+
+// add registers a hole punch waiting for a connection from addr.
+// The returned function must be called when the hole punch is done, it may be called multiple times.
+func (r *holePunchRegistry) add(addr *net.UDPAddr) (<-chan *conn, func(), error) {
+	key := addr.String()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.waiting == nil {
+		r.waiting = make(map[string]chan *conn)
+	}
+	if _, ok := r.waiting[key]; ok {
+		return nil, nil, fmt.Errorf("already hole punching to %s", addr)
+	}
+	ch := make(chan *conn, 1)
+	r.waiting[key] = ch
+	return ch, func() {
+		r.mutex.Lock()
+		delete(r.waiting, key)
+		r.mutex.Unlock()
+	}, nil
+}
+
+// deliver hands an accepted connection to the hole punch waiting for it.
+// It returns false if no hole punch is waiting for a connection from the remote address of c.
+func (r *holePunchRegistry) deliver(c *conn) bool {
+	key := c.sess.RemoteAddr().String()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ch, ok := r.waiting[key]
+	if !ok {
+		return false
+	}
+	delete(r.waiting, key)
+	ch <- c
+	return true
+}
+
+// holePunch is the server side of a hole punch: it sends packets to the client,
+// until the client's connection is accepted.
+func (t *transport) holePunch(ctx context.Context, raddr ma.Multiaddr, p peer.ID, timings *handshakeTimer) (*conn, error) {
+	_, localPeer, _, _ := t.identity()
+	if p == localPeer {
+		return nil, ErrDialToSelf
+	}
+	if t.config.gater != nil && !t.config.gater.InterceptAddrDial(p, raddr) {
+		return nil, ErrGated
+	}
+	netw, _, udpAddr, err := resolveUDPAddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := t.acquireConn(ctx, netw, udpAddr, p)
+	if err != nil {
+		return nil, err
+	}
+	timings.socketAcquired = time.Now()
+	connChan, done, err := t.holePunches.add(udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if err := waitForDialTime(ctx); err != nil {
+		return nil, err
+	}
+
+	endRegion := t.config.startRegion(ctx, "hole punch")
+	defer endRegion()
+	interval := holePunchInterval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case c := <-connChan:
+			timings.done = time.Now()
+			if c.remotePeerID != p {
+				c.sess.CloseWithError(0, ErrPeerIDMismatch.Error())
+				return nil, newDialError(raddr, p, ErrPeerIDMismatch, nil)
+			}
+			if t.config.maxConnLifetime > 0 {
+				c.closeAfterLifetime(t.config.maxConnLifetime)
+			}
+			return c, nil
+		case <-timer.C:
+			if err := sendHolePunchPacket(pconn, udpAddr); err != nil {
+				return nil, err
+			}
+			timer.Reset(interval)
+			if interval *= 2; interval > holePunchMaxInterval {
+				interval = holePunchMaxInterval
+			}
+		case <-ctx.Done():
+			done()
+			// the connection might have been delivered just before the hole punch was removed
+			select {
+			case c := <-connChan:
+				c.Close()
+			default:
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sendHolePunchPacket sends a packet with random content.
+// The first bit is cleared, so that it isn't mistaken for a QUIC packet with a long header, e.g. an Initial.
+// The client drops it, as it doesn't belong to any connection.
+func sendHolePunchPacket(pconn net.PacketConn, addr *net.UDPAddr) error {
+	b := make([]byte, 64)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	b[0] &^= 0x80
+	_, err := pconn.WriteTo(b, addr)
+	return err
+}
+
+type dialAtKey struct{}
+
+// WithDialAt returns a context that delays dials using it until the given time.
+// The socket is acquired immediately, so that the delay is as precise as possible.
+// This allows a hole punch coordinator to start the dials of both peers at the same time.
+func WithDialAt(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, dialAtKey{}, at)
+}
+
+// waitForDialTime waits until the time set by WithDialAt, if any.
+func waitForDialTime(ctx context.Context) error {
+	at, ok := ctx.Value(dialAtKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquireConn returns the socket used by a dial of p using ctx.
+// Hole punches always use the socket of a listener.
+func (t *transport) acquireConn(ctx context.Context, network string, raddr *net.UDPAddr, p peer.ID) (net.PacketConn, error) {
+	if isHolePunch, _ := holePunchFromContext(ctx); isHolePunch {
+		return t.connManager.GetListenConnForAddr(network, raddr)
+	}
+	return t.connManager.GetConnForAddr(network, raddr, p)
+}
+
+// LocalAddrForDial returns the local address of the socket that a dial of raddr using ctx uses.
+func (t *transport) LocalAddrForDial(ctx context.Context, raddr ma.Multiaddr) (ma.Multiaddr, error) {
+	netw, _, udpAddr, err := resolveUDPAddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := t.acquireConn(ctx, netw, udpAddr, "")
+	if err != nil {
+		return nil, err
+	}
+	return toQuicMultiaddr(pconn.LocalAddr())
+}
+
+// WaitForPacket blocks until a packet from raddr is received on any socket of the transport.
+func (t *transport) WaitForPacket(ctx context.Context, raddr ma.Multiaddr) error {
+	_, _, udpAddr, err := resolveUDPAddr(raddr)
+	if err != nil {
+		return err
+	}
+	received, cancel := t.config.packetWatchers.watch(udpAddr)
+	defer cancel()
+	select {
+	case <-received:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// packetWatchers notifies callers of WaitForPacket when a packet is received from the address they're waiting for.
+type packetWatchers struct {
+	// watching is the number of waiting callers, used to avoid taking the mutex for every packet.
+	// It must be accessed atomically.
+	watching int32
+
+	mutex    sync.Mutex
+	watchers map[string][]chan struct{}
+}
+
+// watch returns a channel that is closed when a packet from addr is received.
+// The returned function must be called when the caller stops waiting.
+func (w *packetWatchers) watch(addr *net.UDPAddr) (<-chan struct{}, func()) {
+	key := addr.String()
+	ch := make(chan struct{})
+	w.mutex.Lock()
+	if w.watchers == nil {
+		w.watchers = make(map[string][]chan struct{})
+	}
+	w.watchers[key] = append(w.watchers[key], ch)
+	atomic.AddInt32(&w.watching, 1)
+	w.mutex.Unlock()
+	return ch, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		chans := w.watchers[key]
+		for i, c := range chans {
+			if c == ch {
+				w.watchers[key] = append(chans[:i], chans[i+1:]...)
+				if len(w.watchers[key]) == 0 {
+					delete(w.watchers, key)
+				}
+				atomic.AddInt32(&w.watching, -1)
+				return
+			}
+		}
+	}
+}
+
+func (w *packetWatchers) received(addr net.Addr) {
+	if atomic.LoadInt32(&w.watching) == 0 {
+		return
+	}
+	key := addr.String()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	chans, ok := w.watchers[key]
+	if !ok {
+		return
+	}
+	for _, c := range chans {
+		close(c)
+	}
+	delete(w.watchers, key)
+	atomic.AddInt32(&w.watching, -int32(len(chans)))
+}
+
+// packetWatchConn notifies the packetWatchers of every received packet.
+type packetWatchConn struct {
+	net.PacketConn
+	watchers *packetWatchers
+}
+
+func (c *packetWatchConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.watchers.received(addr)
+	}
+	return n, addr, err
+}
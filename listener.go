@@ -3,9 +3,13 @@ package libp2pquic
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
 
@@ -16,19 +20,66 @@ import (
 
 var quicListenAddr = quic.ListenAddr
 
+// Listener is a QUIC listener.
+// It is returned by Listen and ListenMany, and extends the tpt.Listener with QUIC specific functionality.
+type Listener interface {
+	tpt.Listener
+
+	// Multiaddrs returns the multiaddrs that the listener can be reached at, for advertising them to other peers.
+	// If the listener is listening on the unspecified address (0.0.0.0 or ::), these are the addresses
+	// of all network interfaces of the same IP family. They are looked up on every call.
+	// For a listener returned by ListenMany, the multiaddrs of all addresses are returned.
+	Multiaddrs() []ma.Multiaddr
+	// ExternalMultiaddr returns the multiaddr that the listener's port is mapped to by the NAT gateway,
+	// see WithPortMapper. It returns nil if the port isn't mapped.
+	ExternalMultiaddr() ma.Multiaddr
+	// Drain closes the listener gracefully: It stops accepting new connections, and waits until the existing
+	// connections are closed, or until ctx is done, before closing the listener and its socket.
+	Drain(ctx context.Context) error
+}
+
 // A listener listens for QUIC connections.
 type listener struct {
 	quicListener quic.Listener
-	transport    tpt.Transport
+	conn         net.PacketConn
+	transport    *transport
+	config       *config
+	// sockets are the sockets of the listener, one per shard
+	sockets          []net.PacketConn
+	closeSocketsOnce sync.Once
 
 	privKey        ic.PrivKey
 	localPeer      peer.ID
 	localMultiaddr ma.Multiaddr
+
+	tlsMutex sync.RWMutex
+	// tlsConf and specTLSConf are used for all new handshakes. They are replaced when the certificate is rotated.
+	// specTLSConf is used for the handshake of the libp2p TLS specification, it is nil unless enabled.
+	tlsConf, specTLSConf *tls.Config
+
+	inboundMutex sync.Mutex
+	// inboundConns is the number of open accepted connections. It is only counted if WithMaxInboundConns is used.
+	inboundConns       int
+	aboveHighWatermark bool
+
+	handshakeMutex sync.Mutex
+	// handshakeStarts are the times the ClientHellos of the running handshakes were received, by remote address.
+	// They are only recorded if metrics are collected.
+	handshakeStarts map[string]time.Time
+
+	// acceptCtx is canceled when the listener is drained. New handshakes are rejected afterwards.
+	acceptCtx     context.Context
+	stopAccepting context.CancelFunc
+
+	// stopPortMapping stops renewing the port mapping, and deletes it. It is nil if no PortMapper is configured.
+	stopPortMapping   context.CancelFunc
+	externalMutex     sync.Mutex
+	externalMultiaddr ma.Multiaddr
 }
 
-var _ tpt.Listener = &listener{}
+var _ Listener = &listener{}
 
-func newListener(addr ma.Multiaddr, transport tpt.Transport, localPeer peer.ID, key ic.PrivKey, tlsConf *tls.Config) (tpt.Listener, error) {
+func newListener(addr ma.Multiaddr, transport *transport, localPeer peer.ID, key ic.PrivKey, tlsConf, specTLSConf *tls.Config, conf *config) (tpt.Listener, error) {
 	lnet, host, err := manet.DialArgs(addr)
 	if err != nil {
 		return nil, err
@@ -37,45 +88,259 @@ func newListener(addr ma.Multiaddr, transport tpt.Transport, localPeer peer.ID,
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.ListenUDP(lnet, laddr)
+	l := &listener{
+		transport:   transport,
+		config:      conf,
+		privKey:     key,
+		localPeer:   localPeer,
+		tlsConf:     tlsConf,
+		specTLSConf: specTLSConf,
+	}
+	l.acceptCtx, l.stopAccepting = context.WithCancel(context.Background())
+	// GetConfigForClient is called when the ClientHello is received.
+	// This allows us to reject the connection before completing the handshake,
+	// to select the handshake, and to use the current certificate.
+	baseTLSConf := tlsConf
+	if baseTLSConf == nil {
+		baseTLSConf = specTLSConf
+	}
+	quicTLSConf := baseTLSConf.Clone()
+	quicTLSConf.GetConfigForClient = l.getConfigForClient
+	var conns []net.PacketConn
+	if conf.listenerShards > 1 {
+		conns, err = conf.listenUDPShards(lnet, laddr, conf.listenerShards)
+	} else {
+		var conn net.PacketConn
+		conn, err = conf.listenUDP(lnet, laddr)
+		conns = []net.PacketConn{conn}
+	}
 	if err != nil {
 		return nil, err
 	}
-	ln, err := quic.Listen(conn, tlsConf, quicConfig)
+	// The local multiaddr is needed by the connection gater as soon as the listener receives packets.
+	l.localMultiaddr, err = toQuicMultiaddr(conns[0].LocalAddr())
 	if err != nil {
+		for _, conn := range conns {
+			conn.Close()
+		}
 		return nil, err
 	}
-	localMultiaddr, err := toQuicMultiaddr(ln.Addr())
+	// the socket that is reused for dialing, nil if the listener is sharded
+	var conn net.PacketConn
+	if len(conns) > 1 {
+		l.quicListener, err = listenShards(conns, quicTLSConf, conf.quicConfig)
+	} else {
+		conn = conns[0]
+		l.quicListener, err = quic.Listen(conn, quicTLSConf, conf.quicConfig)
+	}
 	if err != nil {
+		for _, conn := range conns {
+			conn.Close()
+		}
 		return nil, err
 	}
-	return &listener{
-		quicListener:   ln,
-		transport:      transport,
-		privKey:        key,
-		localPeer:      localPeer,
-		localMultiaddr: localMultiaddr,
-	}, nil
+	l.conn = conn
+	l.sockets = conns
+	if conn != nil {
+		transport.connManager.addListenConn(lnet, conn)
+	}
+	transport.addListener(l)
+	if conf.portMapper != nil && !laddr.IP.IsLoopback() {
+		var ctx context.Context
+		ctx, l.stopPortMapping = context.WithCancel(context.Background())
+		go l.mapPort(ctx, conf.portMapper, l.quicListener.Addr().(*net.UDPAddr).Port)
+	}
+	return l, nil
+}
+
+func (l *listener) getConfigForClient(info *tls.ClientHelloInfo) (*tls.Config, error) {
+	if l.acceptCtx.Err() != nil {
+		return nil, errListenerDraining
+	}
+	if err := l.checkClientHello(info); err != nil {
+		l.config.debugw("rejected handshake", "addr", l.localMultiaddr, "remote_addr", info.Conn.RemoteAddr(), "error", err)
+		if l.config.metrics != nil {
+			l.config.metrics.accepted(0, err)
+		}
+		return nil, err
+	}
+	if l.config.metrics != nil {
+		l.handshakeStarted(info.Conn.RemoteAddr())
+	}
+	l.tlsMutex.RLock()
+	defer l.tlsMutex.RUnlock()
+	if l.specTLSConf != nil {
+		for _, proto := range info.SupportedProtos {
+			if proto == alpn {
+				return l.specTLSConf, nil
+			}
+		}
+	}
+	if l.tlsConf == nil {
+		return nil, errDefaultHandshakeUnsupported
+	}
+	return l.tlsConf, nil
+}
+
+// handshakeStarted records the time the ClientHello of a handshake was received.
+// Handshakes that didn't complete within the handshake timeout are forgotten.
+func (l *listener) handshakeStarted(addr net.Addr) {
+	timeout := l.config.quicConfig.HandshakeTimeout
+	if timeout == 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	now := time.Now()
+	l.handshakeMutex.Lock()
+	defer l.handshakeMutex.Unlock()
+	if l.handshakeStarts == nil {
+		l.handshakeStarts = make(map[string]time.Time)
+	}
+	for a, start := range l.handshakeStarts {
+		if now.Sub(start) > timeout {
+			delete(l.handshakeStarts, a)
+		}
+	}
+	l.handshakeStarts[addr.String()] = now
+}
+
+// handshakeDuration returns the duration of the handshake with addr, or 0 if its start is unknown.
+func (l *listener) handshakeDuration(addr net.Addr) time.Duration {
+	l.handshakeMutex.Lock()
+	defer l.handshakeMutex.Unlock()
+	start, ok := l.handshakeStarts[addr.String()]
+	if !ok {
+		return 0
+	}
+	delete(l.handshakeStarts, addr.String())
+	return time.Since(start)
+}
+
+// checkClientHello checks if a connection is rejected before the handshake,
+// by the inbound connection limit, the inbound IP policy, the handshake rate limit or the connection gater.
+func (l *listener) checkClientHello(info *tls.ClientHelloInfo) error {
+	if l.config.inboundIPPolicy != nil {
+		if addr, ok := info.Conn.RemoteAddr().(*net.UDPAddr); !ok || !l.config.inboundIPPolicy(addr.IP) {
+			return fmt.Errorf("connection from %s rejected by the inbound IP policy", info.Conn.RemoteAddr())
+		}
+	}
+	if l.inboundConnLimitReached() {
+		return errInboundConnLimitExceeded
+	}
+	if l.config.handshakeRateLimiter != nil {
+		if addr, ok := info.Conn.RemoteAddr().(*net.UDPAddr); ok && !l.config.handshakeRateLimiter.allow(addr.IP) {
+			return errHandshakeRateLimited
+		}
+	}
+	if l.config.gater != nil {
+		remoteMultiaddr, err := toQuicMultiaddr(info.Conn.RemoteAddr())
+		if err != nil {
+			return err
+		}
+		if !l.config.gater.InterceptAccept(&connMultiaddrs{local: l.localMultiaddr, remote: remoteMultiaddr}) {
+			return ErrGated
+		}
+	}
+	return nil
+}
+
+// setTLSConfig sets the TLS configs used for new handshakes.
+func (l *listener) setTLSConfig(tlsConf, specTLSConf *tls.Config) {
+	l.tlsMutex.Lock()
+	l.tlsConf = tlsConf
+	l.specTLSConf = specTLSConf
+	l.tlsMutex.Unlock()
+}
+
+func listenShards(conns []net.PacketConn, tlsConf *tls.Config, quicConf *quic.Config) (quic.Listener, error) {
+	listeners := make([]quic.Listener, 0, len(conns))
+	for _, conn := range conns {
+		ln, err := quic.Listen(conn, tlsConf, quicConf)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return newShardedListener(listeners), nil
 }
 
 // Accept accepts new connections.
 func (l *listener) Accept() (tpt.CapableConn, error) {
 	for {
-		sess, err := l.quicListener.Accept(context.Background())
+		sess, err := l.quicListener.Accept(l.acceptCtx)
 		if err != nil {
+			if l.acceptCtx.Err() != nil {
+				err = errListenerDraining
+			}
+			l.config.debugw("listener stopped accepting", "addr", l.localMultiaddr, "error", err)
 			return nil, err
 		}
+		ctx, endTask := l.config.startTask(context.Background(), "quic accept")
+		endRegion := l.config.startRegion(ctx, "setup connection")
 		conn, err := l.setupConn(sess)
+		endRegion()
+		if err == nil {
+			l.config.tracePeer(ctx, conn.remotePeerID)
+		} else {
+			traceError(ctx, err)
+		}
+		endTask()
+		if l.config.handshakeRecorder != nil {
+			remoteMultiaddr, _ := toQuicMultiaddr(sess.RemoteAddr())
+			var remotePeer peer.ID
+			if conn != nil {
+				remotePeer = conn.remotePeerID
+			}
+			l.config.handshakeRecorder(newHandshakeRecord(network.DirInbound, remoteMultiaddr, remotePeer, time.Time{}, conn, err))
+		}
+		if l.config.metrics != nil {
+			l.config.metrics.accepted(l.handshakeDuration(sess.RemoteAddr()), err)
+		}
 		if err != nil {
-			sess.CloseWithError(0, err.Error())
+			var code quic.ErrorCode
+			switch err {
+			case ErrGated:
+				code = ErrorCodeGated
+			case ErrResourceLimitExceeded:
+				code = ErrorCodeResourceLimitExceeded
+			case errInboundConnLimitExceeded:
+				code = ErrorCodeConnLimitExceeded
+			}
+			sess.CloseWithError(code, err.Error())
+			l.config.debugw("rejected connection", "addr", l.localMultiaddr, "remote_addr", sess.RemoteAddr(), "error", err)
+			continue
+		}
+		if err := l.transport.conns.add(conn); err != nil {
+			l.config.debugw("closed duplicate connection", "peer", conn.remotePeerID, "remote_addr", conn.remoteMultiaddr)
 			continue
 		}
+		if l.transport.holePunches.deliver(conn) {
+			l.config.debugw("accepted hole punched connection", "addr", l.localMultiaddr, "peer", conn.remotePeerID, "remote_addr", conn.remoteMultiaddr)
+			continue
+		}
+		l.config.debugw("accepted connection", "addr", l.localMultiaddr, "peer", conn.remotePeerID, "remote_addr", conn.remoteMultiaddr)
+		if l.config.metrics != nil {
+			l.config.metrics.connOpened(conn)
+		}
+		if l.config.maxConnLifetime > 0 {
+			conn.closeAfterLifetime(l.config.maxConnLifetime)
+		}
+		if l.config.inboundActivityDeadline > 0 {
+			conn.closeIfInactive(l.config.inboundActivityDeadline)
+		}
+		l.config.reportObservedAddrs(conn)
+		if l.config.onConnected != nil {
+			l.config.onConnected(conn)
+		}
 		return conn, nil
 	}
 }
 
-func (l *listener) setupConn(sess quic.Session) (tpt.CapableConn, error) {
-	remotePubKey, err := getRemotePubKey(sess.ConnectionState().PeerCertificates)
+func (l *listener) setupConn(sess quic.Session) (*conn, error) {
+	state := sess.ConnectionState()
+	remotePubKey, err := verifyRemoteChain(state.NegotiatedProtocol, state.PeerCertificates, l.config.ignoreCertTimeValidity)
 	if err != nil {
 		return nil, err
 	}
@@ -83,25 +348,60 @@ func (l *listener) setupConn(sess quic.Session) (tpt.CapableConn, error) {
 	if err != nil {
 		return nil, err
 	}
+	if l.config.allowedInboundPeers != nil {
+		if _, ok := l.config.allowedInboundPeers[remotePeerID]; !ok {
+			return nil, fmt.Errorf("peer %s is not allowed to connect", remotePeerID.Pretty())
+		}
+	}
 	remoteMultiaddr, err := toQuicMultiaddr(sess.RemoteAddr())
 	if err != nil {
 		return nil, err
 	}
-	return &conn{
+	closeCtx, closeCancel := context.WithCancel(sess.Context())
+	c := &conn{
 		sess:            sess,
 		transport:       l.transport,
+		closeCtx:        closeCtx,
+		closeCancel:     closeCancel,
 		localPeer:       l.localPeer,
 		localMultiaddr:  l.localMultiaddr,
 		privKey:         l.privKey,
 		remoteMultiaddr: remoteMultiaddr,
 		remotePeerID:    remotePeerID,
 		remotePubKey:    remotePubKey,
-	}, nil
+		direction:       network.DirInbound,
+		version:         l.config.version(),
+		opened:          time.Now(),
+	}
+	if l.config.gater != nil && !l.config.gater.InterceptSecured(network.DirInbound, remotePeerID, c) {
+		closeCancel()
+		return nil, ErrGated
+	}
+	if err := c.openScope(l.config.resourceManager, l.config.quicConfig); err != nil {
+		closeCancel()
+		return nil, err
+	}
+	if err := l.trackInboundConn(c); err != nil {
+		closeCancel()
+		return nil, err
+	}
+	return c, nil
 }
 
-// Close closes the listener.
+// Close closes the listener, and the connections it accepted.
+// Connections dialed from the listener's socket keep using it, so the socket is only closed once they are closed too.
 func (l *listener) Close() error {
-	return l.quicListener.Close()
+	if l.stopPortMapping != nil {
+		l.stopPortMapping()
+	}
+	l.transport.removeListener(l)
+	l.transport.connManager.removeListenConn(l.conn)
+	err := l.quicListener.Close()
+	go func() {
+		l.waitForConns(context.Background())
+		l.closeSockets()
+	}()
+	return err
 }
 
 // Addr returns the address of this listener.
@@ -113,3 +413,14 @@ func (l *listener) Addr() net.Addr {
 func (l *listener) Multiaddr() ma.Multiaddr {
 	return l.localMultiaddr
 }
+
+// Multiaddrs returns the multiaddrs that the listener can be reached at.
+// If the lookup of the interface addresses fails, the multiaddr of the listener is returned.
+func (l *listener) Multiaddrs() []ma.Multiaddr {
+	addrs, err := expandUnspecified(l.localMultiaddr)
+	if err != nil {
+		l.config.debugw("looking up the interface addresses failed", "addr", l.localMultiaddr, "error", err)
+		return []ma.Multiaddr{l.localMultiaddr}
+	}
+	return addrs
+}
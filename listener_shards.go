@@ -0,0 +1,94 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+// A shardedListener merges the sessions accepted by multiple QUIC listeners,
+// listening on the same address using SO_REUSEPORT.
+type shardedListener struct {
+	listeners []quic.Listener
+	sessions  chan quic.Session
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	errOnce sync.Once
+	failed  chan struct{}
+	err     error
+}
+
+var _ quic.Listener = &shardedListener{}
+
+func newShardedListener(listeners []quic.Listener) *shardedListener {
+	l := &shardedListener{
+		listeners: listeners,
+		sessions:  make(chan quic.Session),
+		closed:    make(chan struct{}),
+		failed:    make(chan struct{}),
+	}
+	for _, ln := range listeners {
+		go l.run(ln)
+	}
+	return l
+}
+
+func (l *shardedListener) run(ln quic.Listener) {
+	for {
+		sess, err := ln.Accept(context.Background())
+		if err != nil {
+			l.errOnce.Do(func() {
+				l.err = err
+				close(l.failed)
+			})
+			return
+		}
+		select {
+		case l.sessions <- sess:
+		case <-l.closed:
+			sess.CloseWithError(0, errListenerClosed.Error())
+			return
+		}
+	}
+}
+
+// Accept returns the next session accepted by any of the listeners.
+// If one of the listeners fails, the error is returned.
+func (l *shardedListener) Accept(ctx context.Context) (quic.Session, error) {
+	select {
+	case sess := <-l.sessions:
+		return sess, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	case <-l.failed:
+		return nil, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Addr returns the address that all listeners are listening on.
+func (l *shardedListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+// Close closes all listeners.
+func (l *shardedListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		for _, ln := range l.listeners {
+			if cerr := ln.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
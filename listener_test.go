@@ -64,6 +64,71 @@ var _ = Describe("Listener", func() {
 		})
 	})
 
+	Context("expanding the unspecified address", func() {
+		var origInterfaceAddrs func() ([]net.Addr, error)
+
+		BeforeEach(func() {
+			origInterfaceAddrs = interfaceAddrs
+			interfaceAddrs = func() ([]net.Addr, error) {
+				var addrs []net.Addr
+				for _, cidr := range []string{"127.0.0.1/8", "192.168.1.2/24", "::1/128", "fe80::1/64", "2001:db8::1/64"} {
+					ip, ipNet, err := net.ParseCIDR(cidr)
+					Expect(err).ToNot(HaveOccurred())
+					ipNet.IP = ip
+					addrs = append(addrs, ipNet)
+				}
+				return addrs, nil
+			}
+		})
+
+		AfterEach(func() {
+			interfaceAddrs = origInterfaceAddrs
+		})
+
+		It("returns the interface addresses, for listening on IPv4", func() {
+			ln, err := t.Listen(ma.StringCast("/ip4/0.0.0.0/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			port := ln.Addr().(*net.UDPAddr).Port
+			Expect(ln.(Listener).Multiaddrs()).To(Equal([]ma.Multiaddr{
+				ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic", port)),
+				ma.StringCast(fmt.Sprintf("/ip4/192.168.1.2/udp/%d/quic", port)),
+			}))
+		})
+
+		It("returns the interface addresses, for listening on IPv6", func() {
+			ln, err := t.Listen(ma.StringCast("/ip6/::/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			port := ln.Addr().(*net.UDPAddr).Port
+			Expect(ln.(Listener).Multiaddrs()).To(Equal([]ma.Multiaddr{
+				ma.StringCast(fmt.Sprintf("/ip6/::1/udp/%d/quic", port)),
+				ma.StringCast(fmt.Sprintf("/ip6/2001:db8::1/udp/%d/quic", port)),
+			}))
+		})
+
+		It("returns the address it is listening on, if it isn't the unspecified address", func() {
+			ln, err := t.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			Expect(ln.(Listener).Multiaddrs()).To(Equal([]ma.Multiaddr{ln.Multiaddr()}))
+		})
+
+		It("returns the addresses of all listeners, when listening on multiple addresses", func() {
+			ln, err := t.(Transport).ListenMany([]ma.Multiaddr{
+				ma.StringCast("/ip4/0.0.0.0/udp/0/quic"),
+				ma.StringCast("/ip6/::1/udp/0/quic"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			addrs := ln.Multiaddrs()
+			Expect(addrs).To(HaveLen(3))
+			Expect(addrs[0].String()).To(HavePrefix("/ip4/127.0.0.1/udp/"))
+			Expect(addrs[1].String()).To(HavePrefix("/ip4/192.168.1.2/udp/"))
+			Expect(addrs[2].String()).To(HavePrefix("/ip6/::1/udp/"))
+		})
+	})
+
 	Context("accepting connections", func() {
 		var localAddr ma.Multiaddr
 
@@ -96,5 +161,16 @@ var _ = Describe("Listener", func() {
 			_, err = ln.Accept()
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("closes the socket when it is closed", func() {
+			ln, err := t.Listen(localAddr)
+			Expect(err).ToNot(HaveOccurred())
+			socket := ln.(*listener).conn
+			Expect(ln.Close()).To(Succeed())
+			Eventually(func() error {
+				_, err := socket.WriteTo([]byte("foobar"), ln.Addr())
+				return err
+			}).Should(HaveOccurred())
+		})
 	})
 })
@@ -0,0 +1,73 @@
+package libp2pquic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Logger receives the log output of the transport.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// A FieldLogger is a Logger that supports structured logging.
+// The messages are accompanied by fields, passed as alternating keys and values.
+// The method set matches the one of zap's SugaredLogger, so it can be used directly.
+type FieldLogger interface {
+	Logger
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+type nopLogger struct{}
+
+var _ Logger = nopLogger{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// formatFields appends the fields to the message, as key=value pairs.
+// It is used for loggers that don't implement the FieldLogger interface.
+func formatFields(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = "(missing)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], value)
+	}
+	return b.String()
+}
+
+func (c *config) debugw(msg string, keysAndValues ...interface{}) {
+	if l, ok := c.logger.(FieldLogger); ok {
+		l.Debugw(msg, keysAndValues...)
+	} else {
+		c.logger.Debugf("%s", formatFields(msg, keysAndValues))
+	}
+}
+
+func (c *config) infow(msg string, keysAndValues ...interface{}) {
+	if l, ok := c.logger.(FieldLogger); ok {
+		l.Infow(msg, keysAndValues...)
+	} else {
+		c.logger.Infof("%s", formatFields(msg, keysAndValues))
+	}
+}
+
+func (c *config) warnw(msg string, keysAndValues ...interface{}) {
+	if l, ok := c.logger.(FieldLogger); ok {
+		l.Warnw(msg, keysAndValues...)
+	} else {
+		c.logger.Warnf("%s", formatFields(msg, keysAndValues))
+	}
+}
@@ -0,0 +1,53 @@
+package libp2pquic
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingFieldLogger struct {
+	recordingLogger
+	fields [][]interface{}
+}
+
+var _ FieldLogger = &recordingFieldLogger{}
+
+func (l *recordingFieldLogger) logw(msg string, keysAndValues ...interface{}) {
+	l.log("%s", msg)
+	l.mutex.Lock()
+	l.fields = append(l.fields, keysAndValues)
+	l.mutex.Unlock()
+}
+
+func (l *recordingFieldLogger) Debugw(msg string, kv ...interface{}) { l.logw(msg, kv...) }
+func (l *recordingFieldLogger) Infow(msg string, kv ...interface{})  { l.logw(msg, kv...) }
+func (l *recordingFieldLogger) Warnw(msg string, kv ...interface{})  { l.logw(msg, kv...) }
+func (l *recordingFieldLogger) Errorw(msg string, kv ...interface{}) { l.logw(msg, kv...) }
+
+var _ = Describe("Logger", func() {
+	It("appends the fields to the message", func() {
+		logger := &recordingLogger{}
+		conf, err := newConfig(WithLogger(logger))
+		Expect(err).ToNot(HaveOccurred())
+		conf.debugw("dial failed", "addr", "/ip4/127.0.0.1/udp/1234/quic", "error", errors.New("timeout"))
+		conf.infow("listening")
+		conf.warnw("odd number of fields", "foo")
+		Expect(logger.Messages()).To(Equal([]string{
+			"dial failed addr=/ip4/127.0.0.1/udp/1234/quic error=timeout",
+			"listening",
+			"odd number of fields foo=(missing)",
+		}))
+	})
+
+	It("passes the fields to a FieldLogger", func() {
+		logger := &recordingFieldLogger{}
+		conf, err := newConfig(WithLogger(logger))
+		Expect(err).ToNot(HaveOccurred())
+		conf.warnw("failed to create socket for dialing", "network", "udp4", "error", fmt.Errorf("permission denied"))
+		Expect(logger.Messages()).To(Equal([]string{"failed to create socket for dialing"}))
+		Expect(logger.fields).To(Equal([][]interface{}{{"network", "udp4", "error", fmt.Errorf("permission denied")}}))
+	})
+})
@@ -0,0 +1,254 @@
+package libp2pquic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// handshakeDurationBuckets are the upper bounds of the buckets of the handshake duration histogram, in seconds.
+var handshakeDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects metrics of one or more transports.
+// Use WithMetrics to collect the metrics of a transport.
+// The metrics can be served in the Prometheus text exposition format using ServeHTTP,
+// or registered with a Prometheus registry using the collector of the promcollector package.
+type Metrics struct {
+	// must be the first fields, to guarantee 64-bit alignment for atomic access
+	dials              uint64
+	dialsFailed        uint64
+	handshakesAccepted uint64
+	handshakesRejected uint64
+	packetsSent        uint64
+	packetsReceived    uint64
+	connsInbound       int64
+	connsOutbound      int64
+
+	mutex sync.Mutex
+	// handshakeDurations are the durations of successful handshakes, for dialed and accepted connections
+	handshakeDurationsOutbound handshakeHistogram
+	handshakeDurationsInbound  handshakeHistogram
+}
+
+var _ http.Handler = &Metrics{}
+
+// NewMetrics creates a new Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		handshakeDurationsOutbound: newHandshakeHistogram(),
+		handshakeDurationsInbound:  newHandshakeHistogram(),
+	}
+}
+
+// handshakeHistogram is a histogram of handshake durations.
+type handshakeHistogram struct {
+	buckets []uint64 // one counter per bucket, not cumulative
+	sum     float64
+	num     uint64
+}
+
+func newHandshakeHistogram() handshakeHistogram {
+	return handshakeHistogram{buckets: make([]uint64, len(handshakeDurationBuckets))}
+}
+
+func (h *handshakeHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bound := range handshakeDurationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.num++
+}
+
+// values returns the samples of the histogram in the text exposition format, labeled with the direction.
+func (h *handshakeHistogram) values(direction string) []string {
+	values := make([]string, 0, len(handshakeDurationBuckets)+3)
+	var cumulative uint64
+	for i, bound := range handshakeDurationBuckets {
+		cumulative += h.buckets[i]
+		values = append(values, fmt.Sprintf(`_bucket{direction="%s",le="%s"} %d`, direction, strconv.FormatFloat(bound, 'g', -1, 64), cumulative))
+	}
+	return append(values,
+		fmt.Sprintf(`_bucket{direction="%s",le="+Inf"} %d`, direction, h.num),
+		fmt.Sprintf(`_sum{direction="%s"} %s`, direction, strconv.FormatFloat(h.sum, 'g', -1, 64)),
+		fmt.Sprintf(`_count{direction="%s"} %d`, direction, h.num),
+	)
+}
+
+// dialed records the result of a dial.
+func (m *Metrics) dialed(handshakeDuration time.Duration, err error) {
+	atomic.AddUint64(&m.dials, 1)
+	if err != nil {
+		atomic.AddUint64(&m.dialsFailed, 1)
+		return
+	}
+	m.mutex.Lock()
+	m.handshakeDurationsOutbound.observe(handshakeDuration)
+	m.mutex.Unlock()
+}
+
+// accepted records the result of a handshake accepted by a listener.
+// The handshake duration is only recorded if it is known, i.e. if it is not 0.
+func (m *Metrics) accepted(handshakeDuration time.Duration, err error) {
+	if err != nil {
+		atomic.AddUint64(&m.handshakesRejected, 1)
+		return
+	}
+	atomic.AddUint64(&m.handshakesAccepted, 1)
+	if handshakeDuration == 0 {
+		return
+	}
+	m.mutex.Lock()
+	m.handshakeDurationsInbound.observe(handshakeDuration)
+	m.mutex.Unlock()
+}
+
+// connOpened counts the connection as active, until it is closed.
+func (m *Metrics) connOpened(c *conn) {
+	counter := &m.connsOutbound
+	if c.direction == network.DirInbound {
+		counter = &m.connsInbound
+	}
+	atomic.AddInt64(counter, 1)
+	go func() {
+		<-c.sess.Context().Done()
+		atomic.AddInt64(counter, -1)
+	}()
+}
+
+// A MetricsSnapshot is a point-in-time snapshot of Metrics.
+type MetricsSnapshot struct {
+	Dials, DialsFailed                     uint64
+	HandshakesAccepted, HandshakesRejected uint64
+	// ConnsInbound and ConnsOutbound are the numbers of open accepted and dialed connections.
+	ConnsInbound, ConnsOutbound  int64
+	PacketsSent, PacketsReceived uint64
+	// HandshakeDurationsInbound and HandshakeDurationsOutbound are the durations of successful handshakes,
+	// of accepted and dialed connections. For accepted connections, they are measured from the receipt of the ClientHello.
+	HandshakeDurationsInbound, HandshakeDurationsOutbound HistogramSnapshot
+}
+
+// A HistogramSnapshot is a point-in-time snapshot of a histogram of durations.
+type HistogramSnapshot struct {
+	// Buckets maps the upper bounds of the buckets, in seconds, to the cumulative number of observations.
+	Buckets map[float64]uint64
+	// Sum is the sum of all observations, in seconds.
+	Sum   float64
+	Count uint64
+}
+
+func (h *handshakeHistogram) snapshot() HistogramSnapshot {
+	buckets := make(map[float64]uint64, len(handshakeDurationBuckets))
+	var cumulative uint64
+	for i, bound := range handshakeDurationBuckets {
+		cumulative += h.buckets[i]
+		buckets[bound] = cumulative
+	}
+	return HistogramSnapshot{Buckets: buckets, Sum: h.sum, Count: h.num}
+}
+
+// Snapshot returns a snapshot of the metrics.
+// The counters are read individually, so the snapshot isn't taken atomically.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{
+		Dials:              atomic.LoadUint64(&m.dials),
+		DialsFailed:        atomic.LoadUint64(&m.dialsFailed),
+		HandshakesAccepted: atomic.LoadUint64(&m.handshakesAccepted),
+		HandshakesRejected: atomic.LoadUint64(&m.handshakesRejected),
+		ConnsInbound:       atomic.LoadInt64(&m.connsInbound),
+		ConnsOutbound:      atomic.LoadInt64(&m.connsOutbound),
+		PacketsSent:        atomic.LoadUint64(&m.packetsSent),
+		PacketsReceived:    atomic.LoadUint64(&m.packetsReceived),
+	}
+	m.mutex.Lock()
+	s.HandshakeDurationsInbound = m.handshakeDurationsInbound.snapshot()
+	s.HandshakeDurationsOutbound = m.handshakeDurationsOutbound.snapshot()
+	m.mutex.Unlock()
+	return s
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	writeMetric := func(name, typ, help string, values ...string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, v := range values {
+			fmt.Fprintf(bw, "%s%s\n", name, v)
+		}
+	}
+	writeMetric("libp2p_quic_dials_total", "counter", "Number of dials.",
+		fmt.Sprintf(" %d", atomic.LoadUint64(&m.dials)))
+	writeMetric("libp2p_quic_dials_failed_total", "counter", "Number of failed dials.",
+		fmt.Sprintf(" %d", atomic.LoadUint64(&m.dialsFailed)))
+	writeMetric("libp2p_quic_handshakes_accepted_total", "counter", "Number of handshakes accepted by listeners.",
+		fmt.Sprintf(" %d", atomic.LoadUint64(&m.handshakesAccepted)))
+	writeMetric("libp2p_quic_handshakes_rejected_total", "counter", "Number of handshakes rejected by listeners.",
+		fmt.Sprintf(" %d", atomic.LoadUint64(&m.handshakesRejected)))
+	writeMetric("libp2p_quic_connections", "gauge", "Number of open connections.",
+		fmt.Sprintf(`{direction="inbound"} %d`, atomic.LoadInt64(&m.connsInbound)),
+		fmt.Sprintf(`{direction="outbound"} %d`, atomic.LoadInt64(&m.connsOutbound)))
+	writeMetric("libp2p_quic_packets_sent_total", "counter", "Number of UDP packets sent.",
+		fmt.Sprintf(" %d", atomic.LoadUint64(&m.packetsSent)))
+	writeMetric("libp2p_quic_packets_received_total", "counter", "Number of UDP packets received.",
+		fmt.Sprintf(" %d", atomic.LoadUint64(&m.packetsReceived)))
+
+	m.mutex.Lock()
+	values := append(m.handshakeDurationsInbound.values("inbound"), m.handshakeDurationsOutbound.values("outbound")...)
+	m.mutex.Unlock()
+	writeMetric("libp2p_quic_handshake_duration_seconds", "histogram",
+		"Duration of successful handshakes. For accepted connections, it is measured from the receipt of the ClientHello.", values...)
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// A metricsConn counts the packets sent and received on a socket.
+type metricsConn struct {
+	net.PacketConn
+	metrics *Metrics
+}
+
+func (c *metricsConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		atomic.AddUint64(&c.metrics.packetsReceived, 1)
+	}
+	return n, addr, err
+}
+
+func (c *metricsConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		atomic.AddUint64(&c.metrics.packetsSent, 1)
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+package libp2pquic
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	It("exports the metrics in the Prometheus text format", func() {
+		m := NewMetrics()
+		m.dialed(250*time.Millisecond, nil)
+		m.dialed(2*time.Second, nil)
+		m.dialed(0, errors.New("dial failed"))
+		m.accepted(20*time.Millisecond, nil)
+		m.accepted(0, errors.New("handshake failed"))
+		m.accepted(0, errors.New("handshake failed"))
+
+		var buf bytes.Buffer
+		n, err := m.WriteTo(&buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(BeEquivalentTo(buf.Len()))
+		out := buf.String()
+		Expect(out).To(ContainSubstring("# TYPE libp2p_quic_dials_total counter\nlibp2p_quic_dials_total 3\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_dials_failed_total 1\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshakes_accepted_total 1\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshakes_rejected_total 2\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_connections{direction=\"inbound\"} 0\n"))
+		Expect(out).To(ContainSubstring("# TYPE libp2p_quic_handshake_duration_seconds histogram\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_bucket{direction=\"outbound\",le=\"0.1\"} 0\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_bucket{direction=\"outbound\",le=\"0.25\"} 1\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_bucket{direction=\"outbound\",le=\"2.5\"} 2\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_bucket{direction=\"outbound\",le=\"+Inf\"} 2\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_sum{direction=\"outbound\"} 2.25\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_count{direction=\"outbound\"} 2\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_bucket{direction=\"inbound\",le=\"0.01\"} 0\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_bucket{direction=\"inbound\",le=\"0.025\"} 1\n"))
+		Expect(out).To(ContainSubstring("\nlibp2p_quic_handshake_duration_seconds_count{direction=\"inbound\"} 1\n"))
+	})
+
+	It("takes snapshots", func() {
+		m := NewMetrics()
+		m.dialed(250*time.Millisecond, nil)
+		m.dialed(0, errors.New("dial failed"))
+		m.accepted(20*time.Millisecond, nil)
+		s := m.Snapshot()
+		Expect(s.Dials).To(BeEquivalentTo(2))
+		Expect(s.DialsFailed).To(BeEquivalentTo(1))
+		Expect(s.HandshakesAccepted).To(BeEquivalentTo(1))
+		Expect(s.HandshakeDurationsOutbound.Count).To(BeEquivalentTo(1))
+		Expect(s.HandshakeDurationsOutbound.Buckets).To(HaveKeyWithValue(0.1, uint64(0)))
+		Expect(s.HandshakeDurationsOutbound.Buckets).To(HaveKeyWithValue(0.25, uint64(1)))
+		Expect(s.HandshakeDurationsInbound.Count).To(BeEquivalentTo(1))
+		Expect(s.HandshakeDurationsInbound.Sum).To(BeNumerically("~", 0.02))
+	})
+
+	It("serves the metrics over HTTP", func() {
+		m := NewMetrics()
+		m.dialed(time.Millisecond, nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(w.Body.String()).To(ContainSubstring("\nlibp2p_quic_dials_total 1\n"))
+	})
+})
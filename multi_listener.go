@@ -0,0 +1,141 @@
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var errNoListenAddrs = errors.New("no addresses to listen on")
+
+// A multiListener merges the connections accepted by the listeners of multiple addresses.
+type multiListener struct {
+	listeners []Listener
+	conns     chan tpt.CapableConn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	errOnce sync.Once
+	failed  chan struct{}
+	err     error
+}
+
+var _ Listener = &multiListener{}
+
+func newMultiListener(listeners []Listener) *multiListener {
+	l := &multiListener{
+		listeners: listeners,
+		conns:     make(chan tpt.CapableConn),
+		closed:    make(chan struct{}),
+		failed:    make(chan struct{}),
+	}
+	for _, ln := range listeners {
+		go l.run(ln)
+	}
+	return l
+}
+
+func (l *multiListener) run(ln Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			l.errOnce.Do(func() {
+				l.err = err
+				close(l.failed)
+			})
+			return
+		}
+		select {
+		case l.conns <- conn:
+		case <-l.closed:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next connection accepted on any of the addresses.
+// If one of the listeners fails, the error is returned.
+func (l *multiListener) Accept() (tpt.CapableConn, error) {
+	// Closing the listeners makes them fail, but a closed multiListener should return errListenerClosed.
+	select {
+	case <-l.closed:
+		return nil, errListenerClosed
+	default:
+	}
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	case <-l.failed:
+		return nil, l.err
+	}
+}
+
+// Close closes the listeners of all addresses.
+func (l *multiListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		for _, ln := range l.listeners {
+			if cerr := ln.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener.
+func (l *multiListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+// Multiaddr returns the multiaddr of the first listener.
+func (l *multiListener) Multiaddr() ma.Multiaddr {
+	return l.listeners[0].Multiaddr()
+}
+
+// Multiaddrs returns the multiaddrs of all listeners.
+func (l *multiListener) Multiaddrs() []ma.Multiaddr {
+	var addrs []ma.Multiaddr
+	for _, ln := range l.listeners {
+		addrs = append(addrs, ln.Multiaddrs()...)
+	}
+	return addrs
+}
+
+// ExternalMultiaddr returns the first multiaddr that the port of one of the listeners is mapped to.
+func (l *multiListener) ExternalMultiaddr() ma.Multiaddr {
+	for _, ln := range l.listeners {
+		if addr := ln.ExternalMultiaddr(); addr != nil {
+			return addr
+		}
+	}
+	return nil
+}
+
+// ListenMany listens for new QUIC connections on all of the addresses.
+func (t *transport) ListenMany(addrs []ma.Multiaddr) (Listener, error) {
+	if len(addrs) == 0 {
+		return nil, errNoListenAddrs
+	}
+	listeners := make([]Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := t.Listen(addr)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln.(Listener))
+	}
+	return newMultiListener(listeners), nil
+}
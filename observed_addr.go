@@ -0,0 +1,81 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// maxDstAddrs is the maximum number of remote addresses that destination addresses are recorded for.
+// When it is exceeded, the recorded addresses are discarded.
+const maxDstAddrs = 10000
+
+// ObservedAddrs are the addresses of a new connection, as observed on the wire.
+// They are reported to the handler set by WithObservedAddrsHandler.
+type ObservedAddrs struct {
+	Peer      peer.ID
+	Direction network.Direction
+	// Local is the address that the peer sends its packets to, i.e. our address as seen by the peer.
+	// Behind a NAT, this is the address on the local network, not the external address.
+	// For sockets bound to the unspecified address, it is the destination address of the peer's packets, if known.
+	Local ma.Multiaddr
+	// Remote is the address that the peer's packets are received from, i.e. the peer's address as seen by us.
+	Remote ma.Multiaddr
+}
+
+// dstAddrs records the destination address of the packets received from every remote address.
+// This is the local address the remote peer sent the packets to, which is not known for sockets bound
+// to the unspecified address.
+type dstAddrs struct {
+	mutex sync.Mutex
+	addrs map[string]net.IP
+}
+
+func (d *dstAddrs) record(remote net.Addr, dst net.IP) {
+	key := remote.String()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if ip, ok := d.addrs[key]; ok && ip.Equal(dst) {
+		return
+	}
+	if d.addrs == nil || len(d.addrs) >= maxDstAddrs {
+		d.addrs = make(map[string]net.IP)
+	}
+	d.addrs[key] = dst
+}
+
+func (d *dstAddrs) get(remote net.Addr) net.IP {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.addrs[remote.String()]
+}
+
+// reportObservedAddrs reports the observed addresses of a new connection to the handler, if set.
+func (c *config) reportObservedAddrs(conn *conn) {
+	if c.observedAddrsHandler == nil {
+		return
+	}
+	local := conn.localMultiaddr
+	laddr, ok := conn.sess.LocalAddr().(*net.UDPAddr)
+	if ok && laddr.IP.IsUnspecified() {
+		if dst := c.dstAddrs.get(conn.sess.RemoteAddr()); dst != nil {
+			if addr, err := toQuicMultiaddr(&net.UDPAddr{IP: dst, Port: laddr.Port}); err == nil {
+				local = addr
+			}
+		}
+	}
+	remote, err := toQuicMultiaddr(conn.sess.RemoteAddr())
+	if err != nil {
+		remote = conn.remoteMultiaddr
+	}
+	c.observedAddrsHandler(ObservedAddrs{
+		Peer:      conn.remotePeerID,
+		Direction: conn.direction,
+		Local:     local,
+		Remote:    remote,
+	})
+}
@@ -0,0 +1,906 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	tpt "github.com/libp2p/go-libp2p-core/transport"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// An Option configures the QUIC transport.
+type Option func(*config) error
+
+type config struct {
+	// quicConfig is the QUIC configuration used for dialing and listening.
+	quicConfig *quic.Config
+	// busyPoll is the value of SO_BUSY_POLL set on UDP sockets, in microseconds.
+	busyPoll int
+	// receiveBufferSize and sendBufferSize are the sizes of the socket buffers, in bytes.
+	// If 0, the kernel's default is used.
+	receiveBufferSize, sendBufferSize int
+	// maxSendRate is the maximum rate at which packets are sent to a remote address, in bytes per second.
+	// If 0, the send rate is not limited.
+	maxSendRate, sendRateBurst int
+	// receiveBatchSize is the number of packets received from a UDP socket with a single syscall.
+	// If 0, packets are received one by one.
+	receiveBatchSize int
+	// listenerShards is the number of sockets that listeners open on the same address.
+	listenerShards int
+	// dscp is the DSCP set on all packets. If nil, the DSCP is not set.
+	dscp *uint8
+	// vrf is the name of the VRF device that sockets are bound to.
+	vrf string
+	// dialAddrIPv4 and dialAddrIPv6 are the local addresses that the sockets used for dialing are bound to.
+	// If nil, the sockets are bound to the unspecified address.
+	dialAddrIPv4, dialAddrIPv6 *net.UDPAddr
+	// noListenSocketReuse disables dialing from the sockets of listeners.
+	noListenSocketReuse bool
+	// addressValidationThreshold is the number of unvalidated handshakes above which clients need to validate their address.
+	addressValidationThreshold int
+	// addressValidationPolicy decides if clients need to validate their address. It takes precedence over the threshold.
+	addressValidationPolicy AddressValidationPolicy
+	// clientSessionCache stores the TLS sessions used for resumption. If nil, sessions are not resumed.
+	clientSessionCache tls.ClientSessionCache
+	// statelessResetKey is the key used to generate stateless reset tokens.
+	statelessResetKey []byte
+	// psk is the pre-shared key of the private network. If nil, the transport isn't part of a private network.
+	psk []byte
+	// packetConnFactory creates the sockets used by the transport. If nil, UDP sockets are used.
+	packetConnFactory func(network string, laddr *net.UDPAddr) (net.PacketConn, error)
+	// packetConnMiddlewares are applied to every UDP socket, in order.
+	packetConnMiddlewares []func(net.PacketConn) net.PacketConn
+	// allowedInboundPeers is the list of peers allowed to connect to our listeners.
+	// If nil, all peers are allowed.
+	allowedInboundPeers map[peer.ID]struct{}
+	// inboundIPPolicy decides if a connection from an IP address is accepted.
+	// If nil, connections from all IP addresses are accepted.
+	inboundIPPolicy func(net.IP) bool
+	// handshakeRateLimiter limits the rate of handshakes from every source prefix. If nil, handshakes are not limited.
+	handshakeRateLimiter *handshakeRateLimiter
+	// maxInboundConns is the maximum number of open accepted connections per listener. If 0, the number is not limited.
+	maxInboundConns int
+	// inboundConnsHighWatermark is the number of open accepted connections per listener above which a warning is logged.
+	inboundConnsHighWatermark int
+	// gater decides which connections are established. If nil, all connections are established.
+	gater ConnectionGater
+	// resourceManager accounts for the resources used by connections and streams. If nil, resources are not limited.
+	resourceManager ResourceManager
+	// ignoreCertTimeValidity disables the checks of NotBefore and NotAfter of the peer's certificates.
+	ignoreCertTimeValidity bool
+	// libp2pTLS enables the handshake of the libp2p TLS specification.
+	libp2pTLS bool
+	// maxConnLifetime is the time after which connections are closed. 0 means no limit.
+	maxConnLifetime time.Duration
+	// inboundActivityDeadline is the time within which accepted connections must use a stream. 0 means no deadline.
+	inboundActivityDeadline time.Duration
+	// handshakeRecorder is called for every completed handshake.
+	handshakeRecorder func(HandshakeRecord)
+	// onConnected is called for every established connection, before it is returned.
+	onConnected func(tpt.CapableConn)
+	// duplicatePolicy decides what happens when there are multiple connections to the same peer.
+	duplicatePolicy DuplicateConnectionPolicy
+	// socketSelection decides which socket a dial uses, if there are multiple eligible sockets.
+	socketSelection SocketSelectionStrategy
+	// addressFamily is the address family that is dialed first, if a peer has addresses of both families.
+	// If 0, the addresses of both families are raced.
+	addressFamily AddressFamily
+	// connCache keeps the dialed connections for reuse. If nil, connections are not reused.
+	connCache *connCache
+	// metrics collects the metrics of the transport. If nil, no metrics are collected.
+	metrics *Metrics
+	// packetWatchers are notified of every packet received, see Transport.WaitForPacket.
+	packetWatchers *packetWatchers
+	// stun discovers the public addresses of the sockets. If nil, no STUN servers are configured.
+	stun *stunClient
+	// portMapper maps the ports of listeners on the NAT gateway. If nil, ports are not mapped.
+	portMapper PortMapper
+	// observedAddrsHandler is called with the observed addresses of every new connection.
+	observedAddrsHandler func(ObservedAddrs)
+	// dstAddrs records the destination addresses of received packets. It is nil unless observedAddrsHandler is set.
+	dstAddrs *dstAddrs
+	// tracer creates the spans for dials and accepted connections. If nil, no spans are created.
+	tracer Tracer
+	// keyLogWriter receives the TLS secrets of all connections. If nil, the secrets are not logged.
+	keyLogWriter io.Writer
+	// logger receives all log output of the transport.
+	logger Logger
+	// canDialTrace enables logging of the decisions made by CanDial.
+	canDialTrace bool
+	// runtimeTrace enables runtime/trace tasks and regions for dials and accepted connections.
+	runtimeTrace bool
+}
+
+// dialAddr returns the local address that the socket used for dialing on network is bound to,
+// or nil if it is bound to the unspecified address.
+func (c *config) dialAddr(network string) *net.UDPAddr {
+	switch network {
+	case "udp4":
+		return c.dialAddrIPv4
+	case "udp6":
+		return c.dialAddrIPv6
+	default:
+		return nil
+	}
+}
+
+func newConfig(opts ...Option) (*config, error) {
+	qconf := *quicConfig
+	conf := &config{
+		quicConfig:                 &qconf,
+		addressValidationThreshold: defaultAddressValidationThreshold,
+		logger:                     nopLogger{},
+		packetWatchers:             &packetWatchers{},
+	}
+	for _, opt := range opts {
+		if err := opt(conf); err != nil {
+			return nil, err
+		}
+	}
+	if pnet.ForcePrivateNetwork && conf.psk == nil {
+		return nil, pnet.ErrNotInPrivateNetwork
+	}
+	if conf.statelessResetKey != nil {
+		conf.quicConfig.StatelessResetKey = conf.statelessResetKey
+	}
+	if conf.quicConfig.AcceptToken == nil {
+		handshakeTimeout := conf.quicConfig.HandshakeTimeout
+		if handshakeTimeout == 0 {
+			handshakeTimeout = defaultHandshakeTimeout
+		}
+		conf.quicConfig.AcceptToken = newAddressValidator(conf.addressValidationPolicy, conf.addressValidationThreshold, handshakeTimeout).AcceptToken
+	}
+	return conf, nil
+}
+
+// WithQUICConfig sets the QUIC configuration used for dialing and listening, replacing the default configuration.
+// The configuration is copied, later changes to it don't affect the transport.
+// Options that modify the QUIC configuration, like WithMaxStreams and WithMaxUniStreams, must be passed after this option.
+func WithQUICConfig(qconf *quic.Config) Option {
+	return func(c *config) error {
+		if qconf == nil {
+			return errors.New("QUIC config must not be nil")
+		}
+		conf := *qconf
+		c.quicConfig = &conf
+		return nil
+	}
+}
+
+// WithMaxStreams sets the maximum number of streams that a peer may have open concurrently on a connection.
+// By default, peers may open 1000 streams.
+func WithMaxStreams(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("maximum number of streams must be positive")
+		}
+		c.quicConfig.MaxIncomingStreams = n
+		return nil
+	}
+}
+
+// WithMaxUniStreams enables unidirectional streams, see Conn.OpenUniStream and Conn.AcceptUniStream.
+// It sets the maximum number of unidirectional streams that a peer may have open concurrently on a connection.
+// By default, unidirectional streams are disabled.
+func WithMaxUniStreams(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("maximum number of unidirectional streams must be positive")
+		}
+		c.quicConfig.MaxIncomingUniStreams = n
+		return nil
+	}
+}
+
+// WithVersions sets the QUIC versions offered when dialing, and accepted by listeners, in order of preference.
+// By default, all versions supported by quic-go are used.
+// Options that replace the QUIC configuration, like WithQUICConfig, must be passed before this option.
+func WithVersions(versions ...quic.VersionNumber) Option {
+	return func(c *config) error {
+		if len(versions) == 0 {
+			return errors.New("at least one QUIC version is required")
+		}
+		for _, v := range versions {
+			if !isSupportedVersion(v) {
+				return fmt.Errorf("QUIC version %s is not supported", v)
+			}
+		}
+		c.quicConfig.Versions = append([]quic.VersionNumber(nil), versions...)
+		return nil
+	}
+}
+
+// WithHandshakeTimeout sets the time allowed for completing a handshake, for both dialed and accepted connections.
+// Handshakes that don't complete in time fail, even if the context used for dialing is not done yet.
+// Dials fail with ErrHandshakeTimeout.
+// By default, quic-go's handshake timeout of 10 seconds is used.
+// Options that replace the QUIC configuration, like WithQUICConfig, must be passed before this option.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return errors.New("handshake timeout must be positive")
+		}
+		c.quicConfig.HandshakeTimeout = timeout
+		return nil
+	}
+}
+
+// WithIdleTimeout sets the time after which a connection is closed if no packets were received from the peer.
+// By default, quic-go's idle timeout of 30 seconds is used.
+// Options that replace the QUIC configuration, like WithQUICConfig, must be passed before this option.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return errors.New("idle timeout must be positive")
+		}
+		c.quicConfig.IdleTimeout = timeout
+		return nil
+	}
+}
+
+// WithKeepAlive enables or disables keep-alives. Keep-alives are enabled by default.
+// With keep-alives enabled, a PING is sent if no packet was received for half of the peer's idle timeout,
+// so idle connections stay open. The keep-alive interval therefore follows the idle timeout set by the peer,
+// see WithIdleTimeout. quic-go raises idle timeouts of peers below 5 seconds to 5 seconds.
+// Disabling keep-alives saves the packets (and radio wake-ups) of idle connections,
+// at the cost of idle connections being closed after the idle timeout.
+// Options that replace the QUIC configuration, like WithQUICConfig, must be passed before this option.
+func WithKeepAlive(enabled bool) Option {
+	return func(c *config) error {
+		c.quicConfig.KeepAlive = enabled
+		return nil
+	}
+}
+
+// WithAddressValidationThreshold sets the number of handshakes from unvalidated client addresses
+// that listeners allow to be in progress at the same time.
+// Above the threshold, clients are sent a Retry, and have to prove that they own their address
+// by echoing the token contained in the Retry. This costs them an additional round trip,
+// but prevents the listener from being used for amplification attacks.
+// Handshakes are counted as in progress for the handshake timeout.
+// A threshold of 0 requires all clients to validate their address.
+// The default threshold is 100.
+// This option has no effect if the AcceptToken callback was set using WithQUICConfig.
+func WithAddressValidationThreshold(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return errors.New("address validation threshold must not be negative")
+		}
+		c.addressValidationThreshold = n
+		return nil
+	}
+}
+
+// WithAddressValidationPolicy sets a policy that decides if a client has to validate its address,
+// for example to always require validation for clients from certain prefixes.
+// The policy is called for every new connection attempt, before the threshold
+// set by WithAddressValidationThreshold is applied.
+// If it returns DefaultAddressValidation, the threshold applies.
+// This option has no effect if the AcceptToken callback was set using WithQUICConfig.
+func WithAddressValidationPolicy(policy AddressValidationPolicy) Option {
+	return func(c *config) error {
+		c.addressValidationPolicy = policy
+		return nil
+	}
+}
+
+// WithStatelessResetKey sets the key used to generate stateless reset tokens.
+// When receiving a packet for a connection it doesn't know, for example after a restart,
+// the transport sends a stateless reset, allowing the peer to close the connection immediately,
+// instead of waiting for the idle timeout.
+// The peer only accepts the reset if it was generated using the same key as the connection,
+// so the key has to be kept across restarts. It must be at least 32 bytes long, and kept secret.
+// By default, no stateless resets are sent.
+// This option takes precedence over the StatelessResetKey set using WithQUICConfig.
+func WithStatelessResetKey(key []byte) Option {
+	return func(c *config) error {
+		if len(key) < statelessResetKeyLen {
+			return fmt.Errorf("stateless reset key too short: %d bytes, need at least %d bytes", len(key), statelessResetKeyLen)
+		}
+		c.statelessResetKey = append([]byte(nil), key...)
+		return nil
+	}
+}
+
+// WithStatelessResetKeyFile is like WithStatelessResetKey, but reads the key from a file.
+// If the file doesn't exist, a random key is generated, and written to the file.
+func WithStatelessResetKeyFile(path string) Option {
+	return func(c *config) error {
+		key, err := loadOrCreateStatelessResetKey(path)
+		if err != nil {
+			return err
+		}
+		c.statelessResetKey = key
+		return nil
+	}
+}
+
+// WithClientSessionCache enables TLS session resumption when dialing,
+// saving the computation of a full handshake when reconnecting to a peer.
+// Sessions are stored in the cache per peer, for example using tls.NewLRUClientSessionCache.
+// The handshake still takes one round trip, since quic-go doesn't support 0-RTT.
+// Resumed connections are authenticated using the certificate chain stored in the session.
+func WithClientSessionCache(cache tls.ClientSessionCache) Option {
+	return func(c *config) error {
+		c.clientSessionCache = cache
+		return nil
+	}
+}
+
+// WithPrivateNetwork makes the transport part of the private network defined by the 32 byte pre-shared key.
+// Every packet is encrypted and authenticated using the key, so that connections can only be
+// established between nodes holding the key. Packets from other nodes are dropped.
+// This adds 40 bytes to every packet, which can exceed the path MTU on some IPv6 networks.
+// If the environment enforces private networks (see pnet.ForcePrivateNetwork), this option is required.
+func WithPrivateNetwork(psk []byte) Option {
+	return func(c *config) error {
+		if len(psk) != pskLen {
+			return fmt.Errorf("pre-shared key must be %d bytes, got %d bytes", pskLen, len(psk))
+		}
+		c.psk = append([]byte(nil), psk...)
+		return nil
+	}
+}
+
+// WithBusyPoll enables busy polling (SO_BUSY_POLL) on the UDP sockets used by the transport.
+// When receiving packets, the kernel then busy-polls the device queue for up to
+// the given number of microseconds, instead of waiting for an interrupt.
+// This reduces receive latency at the cost of considerably increased CPU usage,
+// and is only useful for specialized low-latency deployments.
+// Setting SO_BUSY_POLL usually requires CAP_NET_ADMIN.
+// This option is only supported on Linux, it is a no-op on other platforms.
+func WithBusyPoll(microseconds int) Option {
+	return func(c *config) error {
+		if microseconds < 0 {
+			return errors.New("busy poll duration must not be negative")
+		}
+		c.busyPoll = microseconds
+		return nil
+	}
+}
+
+// WithSocketBufferSizes sets the sizes of the receive (SO_RCVBUF) and send (SO_SNDBUF) buffers of the UDP sockets, in bytes.
+// The default buffers are small, so high-throughput nodes drop packets when the application can't keep up.
+// A size of 0 keeps the kernel's default.
+// The kernel limits the buffer sizes (on Linux, see net.core.rmem_max and net.core.wmem_max).
+// On Linux, a warning is logged if the kernel allocated smaller buffers than requested.
+func WithSocketBufferSizes(receive, send int) Option {
+	return func(c *config) error {
+		if receive < 0 || send < 0 {
+			return errors.New("socket buffer sizes must not be negative")
+		}
+		c.receiveBufferSize = receive
+		c.sendBufferSize = send
+		return nil
+	}
+}
+
+// WithReceiveBatchSize sets the number of packets that are received from a UDP socket with a single syscall.
+// At high packet rates, this reduces the CPU spent on syscalls.
+// On Linux, packets are received using recvmmsg. On other platforms, packets are still received one by one.
+// quic-go v0.12 sends packets one by one, so sending isn't batched.
+// This option has no effect on sockets created by a packet conn factory.
+func WithReceiveBatchSize(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("receive batch size must be positive")
+		}
+		c.receiveBatchSize = n
+		return nil
+	}
+}
+
+// WithMaxSendRate limits the rate at which packets are sent to a remote address, in bytes per second.
+// This caps the send rate of a connection, so that it doesn't crowd out other traffic on the same link.
+// quic-go v0.12 neither paces packets nor allows configuring a maximum rate, so the limit is enforced on the socket:
+// packets are held back until they fit into the rate, which congestion control perceives as a bottleneck link.
+// Connections to the same remote address that use the same socket share the limit.
+// burst is the number of bytes that may be sent at once, it must be at least 1500 bytes, the size of the largest packet.
+func WithMaxSendRate(bytesPerSecond, burst int) Option {
+	return func(c *config) error {
+		if bytesPerSecond <= 0 {
+			return errors.New("maximum send rate must be positive")
+		}
+		if burst < minSendRateBurst {
+			return fmt.Errorf("send rate burst must be at least %d bytes", minSendRateBurst)
+		}
+		c.maxSendRate = bytesPerSecond
+		c.sendRateBurst = burst
+		return nil
+	}
+}
+
+// WithHandshakeRateLimit limits the rate of handshakes that listeners accept from every source prefix,
+// to handshakesPerSecond, allowing bursts of burst handshakes.
+// Source addresses are grouped by their first ipv4PrefixLen or ipv6PrefixLen bits, e.g. 32 and 128 to limit
+// every address on its own, or 24 and 64 to limit subnets that a single host might use.
+// Handshakes above the limit are rejected when the ClientHello is received, before the expensive cryptographic
+// operations of the handshake, the same way as connections rejected by WithInboundIPPolicy.
+func WithHandshakeRateLimit(handshakesPerSecond float64, burst, ipv4PrefixLen, ipv6PrefixLen int) Option {
+	return func(c *config) error {
+		if handshakesPerSecond <= 0 {
+			return errors.New("handshake rate must be positive")
+		}
+		if burst < 1 {
+			return errors.New("handshake burst must be at least 1")
+		}
+		if ipv4PrefixLen < 0 || ipv4PrefixLen > 32 || ipv6PrefixLen < 0 || ipv6PrefixLen > 128 {
+			return errors.New("invalid prefix length")
+		}
+		c.handshakeRateLimiter = newHandshakeRateLimiter(handshakesPerSecond, burst, ipv4PrefixLen, ipv6PrefixLen)
+		return nil
+	}
+}
+
+// WithMaxInboundConns limits the number of connections that are open at the same time on every listener.
+// Once max connections are open, new handshakes are refused when the ClientHello is received,
+// the same way as connections rejected by WithInboundIPPolicy.
+// highWatermark is a soft limit: when the number of open connections reaches it, a warning is logged,
+// but connections are still accepted. It must not be larger than max.
+// Connections count as open from the moment Accept processes them, until they are closed.
+func WithMaxInboundConns(max, highWatermark int) Option {
+	return func(c *config) error {
+		if max <= 0 {
+			return errors.New("maximum number of inbound connections must be positive")
+		}
+		if highWatermark <= 0 || highWatermark > max {
+			return errors.New("high watermark must be positive, and not larger than the maximum number of inbound connections")
+		}
+		c.maxInboundConns = max
+		c.inboundConnsHighWatermark = highWatermark
+		return nil
+	}
+}
+
+// WithListenerShards makes listeners open n sockets on the same address, using SO_REUSEPORT.
+// The kernel distributes incoming packets between the sockets based on the sender's address,
+// and the packets of each socket are processed on a separate goroutine.
+// This allows scaling the processing of incoming packets across multiple cores.
+// Accept returns the connections accepted on any of the sockets.
+// The sockets of sharded listeners are not reused for dialing, since the kernel might
+// deliver the response packets of dialed connections to a different socket.
+// This option is only supported on Linux.
+func WithListenerShards(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			return errors.New("number of listener shards must be positive")
+		}
+		if err := checkListenerShards(n); err != nil {
+			return err
+		}
+		c.listenerShards = n
+		return nil
+	}
+}
+
+// WithDSCP marks all packets sent by the transport with the given DSCP (Differentiated Services Code Point),
+// for example 46 (EF) or 34 (AF41), such that the network can prioritize them.
+// The DSCP is set using IP_TOS on IPv4 sockets and IPV6_TCLASS on IPv6 sockets, both for dialing and for listening.
+// This option is only supported on Linux.
+func WithDSCP(dscp uint8) Option {
+	return func(c *config) error {
+		if dscp > 63 {
+			return fmt.Errorf("invalid DSCP: %d", dscp)
+		}
+		if err := checkDSCP(); err != nil {
+			return err
+		}
+		c.dscp = &dscp
+		return nil
+	}
+}
+
+// WithVRF binds all sockets, both for dialing and for listening, to the VRF device with the given name,
+// such that traffic is routed using the VRF's routing table.
+// This is only supported on Linux, and usually requires the CAP_NET_RAW capability.
+func WithVRF(name string) Option {
+	return func(c *config) error {
+		if err := checkVRF(name); err != nil {
+			return err
+		}
+		c.vrf = name
+		return nil
+	}
+}
+
+// WithDialLocalAddr binds the socket used for dialing to a local address, for example to choose
+// the interface that connections originate from on a multi-homed host.
+// The address family of addr determines if it is used for dialing IPv4 or IPv6 addresses.
+// Pass this option twice to configure both address families.
+// If the port is 0, a random port is used.
+// For the configured address families, the sockets of listeners are not reused for dialing.
+func WithDialLocalAddr(addr *net.UDPAddr) Option {
+	return func(c *config) error {
+		if addr == nil || addr.IP == nil {
+			return errors.New("dial address must have an IP")
+		}
+		if addr.IP.To4() != nil {
+			c.dialAddrIPv4 = addr
+		} else {
+			c.dialAddrIPv6 = addr
+		}
+		return nil
+	}
+}
+
+// WithoutListenSocketReuse disables dialing from the sockets of listeners.
+// By default, dials use the socket of a listener of the same address family,
+// such that outgoing connections use the port that we're listening on.
+// This is required for NAT traversal.
+// Only sockets listening on the unspecified address are used,
+// and sockets listening on a loopback address when dialing a loopback address.
+// With this option, dials always use a separate socket, bound to a random port.
+func WithoutListenSocketReuse() Option {
+	return func(c *config) error {
+		c.noListenSocketReuse = true
+		return nil
+	}
+}
+
+// WithPacketConnFactory replaces the UDP sockets used by the transport with the packet conns created by factory,
+// for example to run QUIC over a userspace overlay network.
+// The factory is called with the network ("udp4" or "udp6") and the local address to listen on.
+// The address is 0.0.0.0:0 or [::]:0 for the sockets used for dialing.
+// It may return newly created or pre-built packet conns,
+// but the LocalAddr of the packet conns must be a *net.UDPAddr.
+// The socket options set by WithBusyPoll and WithVRF are not applied to these packet conns,
+// the packet conn middlewares are.
+func WithPacketConnFactory(factory func(network string, laddr *net.UDPAddr) (net.PacketConn, error)) Option {
+	return func(c *config) error {
+		c.packetConnFactory = factory
+		return nil
+	}
+}
+
+// WithPacketConnMiddleware wraps the UDP sockets used by the transport, both for dialing and for listening.
+// Middlewares are applied in the order they are passed: The first middleware wraps the socket,
+// the second middleware wraps the result of the first one, and so on.
+// This option can be passed multiple times, subsequent middlewares are appended to the chain.
+// Middlewares must preserve datagram boundaries: Every packet written to or read from
+// the wrapped net.PacketConn corresponds to exactly one QUIC packet.
+func WithPacketConnMiddleware(middlewares ...func(net.PacketConn) net.PacketConn) Option {
+	return func(c *config) error {
+		c.packetConnMiddlewares = append(c.packetConnMiddlewares, middlewares...)
+		return nil
+	}
+}
+
+// WithSTUNServers sets the STUN servers used by DiscoverPublicAddr, as host:port.
+// The servers are tried in order, until one of them responds.
+func WithSTUNServers(servers ...string) Option {
+	return func(c *config) error {
+		if len(servers) == 0 {
+			return errors.New("at least one STUN server is required")
+		}
+		for _, server := range servers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				return fmt.Errorf("invalid STUN server %s: %s", server, err)
+			}
+		}
+		c.stun = newSTUNClient(servers)
+		return nil
+	}
+}
+
+// WithPortMapper maps the ports of listeners on the NAT gateway, so that peers can connect from outside the local network.
+// The mapping is requested when listening, renewed periodically, and deleted when the listener is closed.
+// The external address is returned by Listener.ExternalMultiaddr.
+// Listeners on loopback addresses are not mapped.
+func WithPortMapper(mapper PortMapper) Option {
+	return func(c *config) error {
+		if mapper == nil {
+			return errors.New("port mapper must not be nil")
+		}
+		c.portMapper = mapper
+		return nil
+	}
+}
+
+// WithAllowedInboundPeers restricts which peers are allowed to connect to our listeners.
+// Connections from all other peers are closed right after the handshake.
+// This option can be passed multiple times, the allowed peers are added to the list.
+// By default, all peers are allowed to connect.
+func WithAllowedInboundPeers(peers ...peer.ID) Option {
+	return func(c *config) error {
+		if c.allowedInboundPeers == nil {
+			c.allowedInboundPeers = make(map[peer.ID]struct{}, len(peers))
+		}
+		for _, p := range peers {
+			c.allowedInboundPeers[p] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithConnectionGater sets the ConnectionGater that decides which connections are established.
+// Dials are checked before dialing and after the handshake,
+// connections accepted by listeners are checked before and after the handshake.
+func WithConnectionGater(gater ConnectionGater) Option {
+	return func(c *config) error {
+		c.gater = gater
+		return nil
+	}
+}
+
+// WithResourceManager sets the ResourceManager that accounts for the resources used by connections and streams.
+// Connections reserve their receive flow control window, streams their stream receive flow control window.
+// Connections refused by the ResourceManager are closed with ErrorCodeResourceLimitExceeded,
+// and Dial returns the error of the ResourceManager.
+// When opening a stream is refused, OpenStream returns the error, streams opened by the peer are reset.
+// Use NewResourceManager to create a ResourceManager with per-peer and total limits.
+func WithResourceManager(rm ResourceManager) Option {
+	return func(c *config) error {
+		c.resourceManager = rm
+		return nil
+	}
+}
+
+// WithIgnoreCertificateTimeValidity disables the checks of the validity period (NotBefore and NotAfter)
+// of the certificates presented by peers.
+// This is useful in environments without a reliable clock.
+// It doesn't weaken the security of the handshake: libp2p certificates are self-signed,
+// the trust is anchored in the peer's identity key and not in the certificate's validity period.
+// The signature of the certificate chain and the peer ID are still verified.
+func WithIgnoreCertificateTimeValidity() Option {
+	return func(c *config) error {
+		c.ignoreCertTimeValidity = true
+		return nil
+	}
+}
+
+// WithLibp2pTLS enables the handshake of the libp2p TLS specification,
+// allowing the transport to interoperate with peers using go-libp2p-tls.
+// Dials use the handshake of the specification.
+// Listeners accept both handshakes: the handshake of the specification is used if the client offers the "libp2p" ALPN.
+// Unlike the default handshake, the handshake of the specification supports all key types, not only RSA.
+// If the identity key isn't an RSA key, listeners only accept the handshake of the specification.
+// Since dials always use the handshake of the specification, they fail to connect to peers that only support the default handshake.
+func WithLibp2pTLS() Option {
+	return func(c *config) error {
+		c.libp2pTLS = true
+		return nil
+	}
+}
+
+// WithHandshakeRecorder sets a function that is called with a summary of every dial and
+// every handshake accepted by a listener, successful or not.
+// It is called synchronously, before Dial or Accept return.
+func WithHandshakeRecorder(recorder func(HandshakeRecord)) Option {
+	return func(c *config) error {
+		c.handshakeRecorder = recorder
+		return nil
+	}
+}
+
+// WithMaxConnectionLifetime limits the time a connection stays open, regardless of its activity.
+// Once the lifetime elapsed, the connection is closed with ErrorCodeMaxLifetimeExceeded,
+// so the peer can tell it apart from a failure.
+// Streams that are still open at this point are closed along with the connection.
+// Applications that need a connection to the peer will therefore periodically reconnect.
+func WithMaxConnectionLifetime(lifetime time.Duration) Option {
+	return func(c *config) error {
+		if lifetime <= 0 {
+			return errors.New("maximum connection lifetime must be positive")
+		}
+		c.maxConnLifetime = lifetime
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the transport.
+// Dials, listeners, accepted connections and the creation of sockets are logged.
+// If the logger implements FieldLogger, it receives the fields of the log messages separately,
+// otherwise they are appended to the message, as key=value pairs.
+// By default, nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(c *config) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithMetrics collects the metrics of the transport: dials, handshakes accepted and rejected by listeners, handshake durations,
+// open connections and UDP packets sent and received.
+// The same Metrics can be used for multiple transports, the metrics are then aggregated.
+// Serve the Metrics over HTTP to make them available for scraping by Prometheus,
+// or register the collector created by promcollector.New with a Prometheus registry.
+func WithMetrics(m *Metrics) Option {
+	return func(c *config) error {
+		if m == nil {
+			return errors.New("metrics must not be nil")
+		}
+		c.metrics = m
+		return nil
+	}
+}
+
+// WithTracer sets the Tracer used to create spans for distributed tracing.
+// Dials create a "quic dial" span, as a child of the span contained in the context passed to Dial,
+// with the child spans "resolve address", "acquire socket" and "handshake".
+// Connections accepted by listeners create a "quic accept" span, with the child span "setup connection".
+// The "peer" attribute is set to the peer ID, and failures are recorded on the span.
+func WithTracer(tracer Tracer) Option {
+	return func(c *config) error {
+		c.tracer = tracer
+		return nil
+	}
+}
+
+// KeyLogEnvKey is the environment variable that must be set to 1 to allow WithKeyLogWriter.
+const KeyLogEnvKey = "LIBP2P_QUIC_ALLOW_KEY_LOG"
+
+// WithKeyLogWriter writes the TLS secrets of all connections, dialed and accepted, to w,
+// in the NSS key log format (the format of SSLKEYLOGFILE), allowing tools like Wireshark to decrypt the traffic.
+// This completely compromises the security of all connections, and must only be used for debugging.
+// To prevent it from being enabled accidentally, the environment variable LIBP2P_QUIC_ALLOW_KEY_LOG must be set to 1,
+// otherwise NewTransport fails.
+func WithKeyLogWriter(w io.Writer) Option {
+	return func(c *config) error {
+		if os.Getenv(KeyLogEnvKey) != "1" {
+			return fmt.Errorf("TLS key logging requires the environment variable %s=1", KeyLogEnvKey)
+		}
+		c.keyLogWriter = w
+		return nil
+	}
+}
+
+// WithObservedAddrsHandler sets a handler that is called with the observed addresses of every new connection,
+// both dialed and accepted, before the connection is returned.
+// Services like identify and AutoNAT can use them to learn how peers see our address.
+// For sockets bound to the unspecified address, the destination address of every received packet is recorded
+// (using IP_PKTINFO or IP_RECVDSTADDR), so that the local address of a connection is known.
+// This option has no effect on sockets created by a packet conn factory.
+func WithObservedAddrsHandler(handler func(ObservedAddrs)) Option {
+	return func(c *config) error {
+		if handler == nil {
+			return errors.New("observed addrs handler must not be nil")
+		}
+		c.observedAddrsHandler = handler
+		c.dstAddrs = &dstAddrs{}
+		return nil
+	}
+}
+
+// WithCanDialTrace logs every address passed to CanDial at debug level,
+// together with the reason why it was accepted or rejected.
+// This is useful for debugging why an address is not dialed, but very verbose.
+func WithCanDialTrace() Option {
+	return func(c *config) error {
+		c.canDialTrace = true
+		return nil
+	}
+}
+
+// WithInboundIPPolicy sets a policy that decides which IP addresses are allowed to connect to our listeners,
+// for example based on a GeoIP or ASN lookup.
+// The policy is consulted when the first packet of the handshake is received.
+// Rejected connections are closed before the handshake completes.
+// The policy is called on the hot path of accepting connections. It must be fast,
+// and should cache the results of expensive lookups.
+func WithInboundIPPolicy(accept func(net.IP) bool) Option {
+	return func(c *config) error {
+		c.inboundIPPolicy = accept
+		return nil
+	}
+}
+
+// WithOnConnected sets a function that is called exactly once for every connection, dialed or accepted,
+// after the handshake completed and the peer ID was verified.
+// It is called synchronously, before Dial or Accept return the connection,
+// so it runs before the application can open or accept any streams on it.
+// Streams opened by the peer in the meantime are queued, and can be accepted afterwards.
+// If the function blocks, it only delays this connection.
+// When dialing, it is also called for the connections established by Probe.
+func WithOnConnected(f func(tpt.CapableConn)) Option {
+	return func(c *config) error {
+		c.onConnected = f
+		return nil
+	}
+}
+
+// WithDuplicateConnectionPolicy sets the policy applied when a new connection to a peer is established,
+// while there already is a connection to that peer.
+// Connections closed by the policy are closed with ErrorCodeDuplicateConnection.
+// If the closed connection is the one being dialed, Dial returns ErrDuplicateConnection.
+// By default, all connections are kept.
+func WithDuplicateConnectionPolicy(policy DuplicateConnectionPolicy) Option {
+	return func(c *config) error {
+		switch policy {
+		case KeepAllConnections, KeepLowerInitiator:
+			c.duplicatePolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown duplicate connection policy: %d", policy)
+		}
+	}
+}
+
+// WithConnectionReuse makes dials return the open connection established by an earlier dial
+// of the same peer and addresses, instead of establishing a new connection.
+// Concurrent dials of the same peer and addresses establish a single connection, which is returned by all of them.
+// The callers share the connection, closing it closes it for all of them.
+// Use WithNewConnection for dials that need a connection of their own.
+// Hole punching dials always establish a new connection. Accepted connections are never reused for dialing.
+func WithConnectionReuse() Option {
+	return func(c *config) error {
+		c.connCache = newConnCache()
+		return nil
+	}
+}
+
+// WithRuntimeTrace annotates dials and accepted connections with runtime/trace tasks and regions.
+// Every dial is traced as a "quic dial" task, with the regions "acquire socket" and "handshake",
+// and the peer ID logged in the "peer" category.
+// Every accepted connection is traced as a "quic accept" task, with the region "setup connection".
+// The annotations are only recorded while an execution trace is running,
+// for example when started using trace.Start or by the /debug/pprof/trace endpoint of net/http/pprof.
+// Use "go tool trace" to view the trace.
+func WithRuntimeTrace() Option {
+	return func(c *config) error {
+		c.runtimeTrace = true
+		return nil
+	}
+}
+
+// WithInboundActivityDeadline closes accepted connections that didn't use any streams within the deadline,
+// reclaiming the resources held by idle or abusive clients.
+// A connection counts as active as soon as a stream was opened, or a stream opened by the peer was accepted.
+// The application therefore needs to accept streams in time.
+// The deadline starts when Accept returns the connection, connections waiting in the accept queue are not affected.
+// Inactive connections are closed with ErrorCodeNoActivity.
+func WithInboundActivityDeadline(deadline time.Duration) Option {
+	return func(c *config) error {
+		if deadline <= 0 {
+			return errors.New("inbound activity deadline must be positive")
+		}
+		c.inboundActivityDeadline = deadline
+		return nil
+	}
+}
+
+// WithAddressFamilyPreference sets the address family that is dialed first, if a peer has addresses of both families,
+// for example when dialing a DNS name that resolves to IPv6 and IPv4 addresses, or when using DialMany.
+// By default, Happy Eyeballs (RFC 8305) races the addresses of both families, starting with IPv6.
+// With a preference, the preferred family is the primary: Happy Eyeballs only races the addresses of the preferred family,
+// and the addresses of the other family are only dialed once all of them failed.
+// This delays the dial if the preferred family is broken, but never establishes a connection using the other family
+// while the preferred family works, even if it is slower.
+func WithAddressFamilyPreference(family AddressFamily) Option {
+	return func(c *config) error {
+		switch family {
+		case FamilyIPv6, FamilyIPv4:
+			c.addressFamily = family
+			return nil
+		default:
+			return fmt.Errorf("unknown address family: %d", family)
+		}
+	}
+}
+
+// WithSocketSelectionStrategy sets the strategy that decides which socket a dial uses,
+// if there are multiple eligible sockets, i.e. multiple listeners whose sockets can be used for dialing.
+// See SocketSelectionStrategy for the available strategies and their trade-offs.
+// Hole punches are not affected, see Transport.LocalAddrForDial.
+// By default, SelectFirstSocket is used.
+func WithSocketSelectionStrategy(strategy SocketSelectionStrategy) Option {
+	return func(c *config) error {
+		switch strategy {
+		case SelectFirstSocket, SelectRoundRobin, SelectLeastLoaded, SelectByPeerID:
+			c.socketSelection = strategy
+			return nil
+		default:
+			return fmt.Errorf("unknown socket selection strategy: %d", strategy)
+		}
+	}
+}
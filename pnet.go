@@ -0,0 +1,64 @@
+package libp2pquic
+
+import (
+	"crypto/rand"
+	"net"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// pskLen is the length of the pre-shared key of a private network.
+const pskLen = 32
+
+// pskOverhead is the number of bytes added to every packet sent in a private network.
+const pskOverhead = 24 + secretbox.Overhead // nonce and authenticator
+
+// A pskConn encrypts and authenticates every packet using the pre-shared key of a private network.
+// Packets that weren't sent by a member of the private network are dropped.
+type pskConn struct {
+	net.PacketConn
+	key [pskLen]byte
+}
+
+func newPSKConn(conn net.PacketConn, psk []byte) *pskConn {
+	c := &pskConn{PacketConn: conn}
+	copy(c.key[:], psk)
+	return c
+}
+
+func (c *pskConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	bufp := getPacketBuffer(len(b) + pskOverhead)
+	defer putPacketBuffer(bufp)
+	buf := *bufp
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < pskOverhead {
+			continue
+		}
+		var nonce [24]byte
+		copy(nonce[:], buf[:24])
+		data, ok := secretbox.Open(b[:0], buf[24:n], &nonce, &c.key)
+		if !ok {
+			continue
+		}
+		return len(data), addr, nil
+	}
+}
+
+func (c *pskConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	bufp := getPacketBuffer(len(b) + pskOverhead)
+	defer putPacketBuffer(bufp)
+	buf := *bufp
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return 0, err
+	}
+	copy(buf, nonce[:])
+	if _, err := c.PacketConn.WriteTo(secretbox.Seal(buf[:24], b, &nonce, &c.key), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
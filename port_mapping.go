@@ -0,0 +1,182 @@
+package libp2pquic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// portMappingLifetime is the lifetime requested for port mappings. Mappings are renewed after half their lifetime.
+	portMappingLifetime = time.Hour
+	// portMappingRetryInterval is the time after which a failed port mapping request is retried.
+	portMappingRetryInterval = time.Minute
+	// portUnmappingTimeout is the time allowed for deleting a port mapping when a listener is closed.
+	portUnmappingTimeout = 5 * time.Second
+)
+
+// A PortMapper requests port mappings from the NAT gateway, e.g. using UPnP-IGD or NAT-PMP.
+// NewNATPMPMapper returns a PortMapper that uses NAT-PMP.
+type PortMapper interface {
+	// MapUDPPort requests a mapping of a UDP port for the given lifetime, or renews an existing mapping.
+	// It returns the external address, and the lifetime granted by the gateway.
+	MapUDPPort(ctx context.Context, internalPort int, lifetime time.Duration) (*net.UDPAddr, time.Duration, error)
+	// UnmapUDPPort deletes the mapping of a UDP port.
+	UnmapUDPPort(ctx context.Context, internalPort int) error
+}
+
+// mapPort maps the listener's port using the port mapper, and renews the mapping until ctx is cancelled.
+// The mapping is deleted afterwards.
+func (l *listener) mapPort(ctx context.Context, mapper PortMapper, port int) {
+	for {
+		wait := portMappingRetryInterval
+		external, lifetime, err := mapper.MapUDPPort(ctx, port, portMappingLifetime)
+		if err == nil {
+			var externalMultiaddr ma.Multiaddr
+			externalMultiaddr, err = toQuicMultiaddr(external)
+			if err == nil {
+				l.config.infow("mapped port", "addr", l.localMultiaddr, "external_addr", externalMultiaddr, "lifetime", lifetime)
+				l.setExternalMultiaddr(externalMultiaddr)
+				wait = lifetime / 2
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			l.config.warnw("port mapping failed", "addr", l.localMultiaddr, "error", err)
+			l.setExternalMultiaddr(nil)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			l.unmapPort(mapper, port)
+			return
+		}
+	}
+}
+
+func (l *listener) unmapPort(mapper PortMapper, port int) {
+	l.setExternalMultiaddr(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), portUnmappingTimeout)
+	defer cancel()
+	if err := mapper.UnmapUDPPort(ctx, port); err != nil {
+		l.config.debugw("deleting the port mapping failed", "addr", l.localMultiaddr, "error", err)
+	}
+}
+
+func (l *listener) setExternalMultiaddr(addr ma.Multiaddr) {
+	l.externalMutex.Lock()
+	l.externalMultiaddr = addr
+	l.externalMutex.Unlock()
+}
+
+// ExternalMultiaddr returns the multiaddr that the listener's port is mapped to by the NAT gateway.
+func (l *listener) ExternalMultiaddr() ma.Multiaddr {
+	l.externalMutex.Lock()
+	defer l.externalMutex.Unlock()
+	return l.externalMultiaddr
+}
+
+const (
+	natPMPPort               = 5351
+	natPMPOpExternalAddr     = 0
+	natPMPOpMapUDP           = 1
+	natPMPResponseBit        = 128
+	natPMPInitialTimeout     = 250 * time.Millisecond
+	natPMPMaxRetransmissions = 9
+)
+
+// natPMPMapper is a PortMapper that uses NAT-PMP (RFC 6886).
+type natPMPMapper struct {
+	gateway *net.UDPAddr
+}
+
+// NewNATPMPMapper returns a PortMapper that requests port mappings from the gateway using NAT-PMP.
+// The gateway is usually the default router of the local network.
+func NewNATPMPMapper(gateway net.IP) PortMapper {
+	return &natPMPMapper{gateway: &net.UDPAddr{IP: gateway, Port: natPMPPort}}
+}
+
+func (m *natPMPMapper) MapUDPPort(ctx context.Context, internalPort int, lifetime time.Duration) (*net.UDPAddr, time.Duration, error) {
+	resp, err := m.request(ctx, natPMPOpExternalAddr, nil, 12)
+	if err != nil {
+		return nil, 0, err
+	}
+	externalIP := net.IP(resp[8:12])
+	req := make([]byte, 10)
+	binary.BigEndian.PutUint16(req[2:4], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort)) // suggested external port
+	binary.BigEndian.PutUint32(req[6:10], uint32(lifetime/time.Second))
+	resp, err = m.request(ctx, natPMPOpMapUDP, req, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	externalPort := int(binary.BigEndian.Uint16(resp[10:12]))
+	granted := time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second
+	return &net.UDPAddr{IP: externalIP, Port: externalPort}, granted, nil
+}
+
+func (m *natPMPMapper) UnmapUDPPort(ctx context.Context, internalPort int) error {
+	req := make([]byte, 10)
+	binary.BigEndian.PutUint16(req[2:4], uint16(internalPort))
+	_, err := m.request(ctx, natPMPOpMapUDP, req, 16)
+	return err
+}
+
+// request sends a NAT-PMP request, retransmitting it until the gateway responds.
+// body is the part of the request following the opcode.
+func (m *natPMPMapper) request(ctx context.Context, op byte, body []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, m.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// interrupt the Read
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	req := append([]byte{0, op}, body...)
+	resp := make([]byte, 16)
+	timeout := natPMPInitialTimeout
+	for i := 0; i <= natPMPMaxRetransmissions; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(resp)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if n < respLen || resp[0] != 0 || resp[1] != natPMPResponseBit+op {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+				return nil, fmt.Errorf("NAT-PMP request failed with result code %d", code)
+			}
+			return resp[:n], nil
+		}
+		timeout *= 2
+	}
+	return nil, errors.New("NAT-PMP gateway didn't respond")
+}
@@ -0,0 +1,102 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockPortMapper struct {
+	mutex    sync.Mutex
+	mapped   []int
+	unmapped []int
+}
+
+var _ PortMapper = &mockPortMapper{}
+
+func (m *mockPortMapper) MapUDPPort(_ context.Context, port int, lifetime time.Duration) (*net.UDPAddr, time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mapped = append(m.mapped, port)
+	return &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}, lifetime, nil
+}
+
+func (m *mockPortMapper) UnmapUDPPort(_ context.Context, port int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.unmapped = append(m.unmapped, port)
+	return nil
+}
+
+func (m *mockPortMapper) Unmapped() []int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.unmapped
+}
+
+var _ = Describe("Port mapping", func() {
+	It("maps the port of a listener", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		mapper := &mockPortMapper{}
+		tr, err := NewTransport(key, WithPortMapper(mapper))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := tr.Listen(ma.StringCast("/ip4/0.0.0.0/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		port := ln.Addr().(*net.UDPAddr).Port
+		Eventually(ln.(Listener).ExternalMultiaddr).Should(Equal(ma.StringCast("/ip4/1.2.3.4/udp/4321/quic")))
+		Expect(ln.Close()).To(Succeed())
+		Eventually(mapper.Unmapped).Should(Equal([]int{port}))
+		Expect(ln.(Listener).ExternalMultiaddr()).To(BeNil())
+	})
+
+	It("requests port mappings using NAT-PMP", func() {
+		gateway, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer gateway.Close()
+		go func() {
+			b := make([]byte, 100)
+			for {
+				n, addr, err := gateway.ReadFromUDP(b)
+				if err != nil {
+					return
+				}
+				var resp []byte
+				switch {
+				case n == 2 && b[1] == 0:
+					resp = make([]byte, 12)
+					copy(resp[8:12], net.IPv4(1, 2, 3, 4).To4())
+				case n == 12 && b[1] == 1:
+					resp = make([]byte, 16)
+					copy(resp[8:10], b[4:6])                      // internal port
+					binary.BigEndian.PutUint16(resp[10:12], 4321) // external port
+					copy(resp[12:16], b[8:12])                    // lifetime
+				default:
+					continue
+				}
+				resp[1] = 128 + b[1]
+				gateway.WriteToUDP(resp, addr)
+			}
+		}()
+
+		mapper := &natPMPMapper{gateway: gateway.LocalAddr().(*net.UDPAddr)}
+		addr, lifetime, err := mapper.MapUDPPort(context.Background(), 1234, time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(addr.String()).To(Equal("1.2.3.4:4321"))
+		Expect(lifetime).To(Equal(time.Hour))
+		Expect(mapper.UnmapUDPPort(context.Background(), 1234)).To(Succeed())
+	})
+})
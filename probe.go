@@ -0,0 +1,49 @@
+package libp2pquic
+
+import (
+	"context"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ProbeResult is the result of a successful Probe.
+type ProbeResult struct {
+	RemotePeer      peer.ID
+	RemotePublicKey ic.PubKey
+	RemoteMultiaddr ma.Multiaddr
+
+	// HandshakeDuration is the time it took to establish the connection.
+	// The handshake takes a single round trip, so this is an upper bound of the RTT.
+	HandshakeDuration time.Duration
+	// TLSVersion and CipherSuite are the TLS parameters negotiated in the handshake.
+	TLSVersion  uint16
+	CipherSuite uint16
+}
+
+// Probe checks if a peer is reachable at the given address.
+// It performs the handshake, including the verification of the peer ID,
+// and closes the connection immediately afterwards.
+// It always establishes a new connection, even if connection reuse is enabled using WithConnectionReuse.
+func (t *transport) Probe(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (ProbeResult, error) {
+	start := time.Now()
+	// Probe always establishes a new connection. With connection reuse, Dial might return a shared connection,
+	// which must not be closed.
+	c, err := t.dialAndReport(ctx, []ma.Multiaddr{raddr}, p)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer c.Close()
+	handshakeDuration := time.Since(start)
+	state := c.(*conn).sess.ConnectionState()
+	return ProbeResult{
+		RemotePeer:        c.RemotePeer(),
+		RemotePublicKey:   c.RemotePublicKey(),
+		RemoteMultiaddr:   c.RemoteMultiaddr(),
+		HandshakeDuration: handshakeDuration,
+		TLSVersion:        state.Version,
+		CipherSuite:       state.CipherSuite,
+	}, nil
+}
@@ -0,0 +1,73 @@
+// Package promcollector exports the metrics of QUIC transports to Prometheus.
+// It is a separate package, so that the transport doesn't depend on the Prometheus client library.
+package promcollector
+
+import (
+	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dialsDesc              = prometheus.NewDesc("libp2p_quic_dials_total", "Number of dials.", nil, nil)
+	dialsFailedDesc        = prometheus.NewDesc("libp2p_quic_dials_failed_total", "Number of failed dials.", nil, nil)
+	handshakesAcceptedDesc = prometheus.NewDesc("libp2p_quic_handshakes_accepted_total",
+		"Number of handshakes accepted by listeners.", nil, nil)
+	handshakesRejectedDesc = prometheus.NewDesc("libp2p_quic_handshakes_rejected_total",
+		"Number of handshakes rejected by listeners.", nil, nil)
+	connsDesc             = prometheus.NewDesc("libp2p_quic_connections", "Number of open connections.", []string{"direction"}, nil)
+	packetsSentDesc       = prometheus.NewDesc("libp2p_quic_packets_sent_total", "Number of UDP packets sent.", nil, nil)
+	packetsReceivedDesc   = prometheus.NewDesc("libp2p_quic_packets_received_total", "Number of UDP packets received.", nil, nil)
+	handshakeDurationDesc = prometheus.NewDesc("libp2p_quic_handshake_duration_seconds",
+		"Duration of successful handshakes. For accepted connections, it is measured from the receipt of the ClientHello.",
+		[]string{"direction"}, nil)
+)
+
+type collector struct {
+	metrics *libp2pquic.Metrics
+}
+
+var _ prometheus.Collector = &collector{}
+
+// New creates a Prometheus collector for the metrics.
+// The metrics are the same as the ones served by Metrics.ServeHTTP.
+// Register the collector with a prometheus.Registry to export the metrics together with the other metrics of the process.
+func New(metrics *libp2pquic.Metrics) prometheus.Collector {
+	return &collector{metrics: metrics}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		dialsDesc,
+		dialsFailedDesc,
+		handshakesAcceptedDesc,
+		handshakesRejectedDesc,
+		connsDesc,
+		packetsSentDesc,
+		packetsReceivedDesc,
+		handshakeDurationDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.metrics.Snapshot()
+	ch <- prometheus.MustNewConstMetric(dialsDesc, prometheus.CounterValue, float64(s.Dials))
+	ch <- prometheus.MustNewConstMetric(dialsFailedDesc, prometheus.CounterValue, float64(s.DialsFailed))
+	ch <- prometheus.MustNewConstMetric(handshakesAcceptedDesc, prometheus.CounterValue, float64(s.HandshakesAccepted))
+	ch <- prometheus.MustNewConstMetric(handshakesRejectedDesc, prometheus.CounterValue, float64(s.HandshakesRejected))
+	ch <- prometheus.MustNewConstMetric(connsDesc, prometheus.GaugeValue, float64(s.ConnsInbound), "inbound")
+	ch <- prometheus.MustNewConstMetric(connsDesc, prometheus.GaugeValue, float64(s.ConnsOutbound), "outbound")
+	ch <- prometheus.MustNewConstMetric(packetsSentDesc, prometheus.CounterValue, float64(s.PacketsSent))
+	ch <- prometheus.MustNewConstMetric(packetsReceivedDesc, prometheus.CounterValue, float64(s.PacketsReceived))
+	for _, h := range []struct {
+		direction string
+		snapshot  libp2pquic.HistogramSnapshot
+	}{
+		{"inbound", s.HandshakeDurationsInbound},
+		{"outbound", s.HandshakeDurationsOutbound},
+	} {
+		ch <- prometheus.MustNewConstHistogram(handshakeDurationDesc, h.snapshot.Count, h.snapshot.Sum, h.snapshot.Buckets, h.direction)
+	}
+}
@@ -0,0 +1,33 @@
+package promcollector
+
+import (
+	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collector", func() {
+	It("exports the metrics to a registry", func() {
+		registry := prometheus.NewPedanticRegistry()
+		Expect(registry.Register(New(libp2pquic.NewMetrics()))).To(Succeed())
+		families, err := registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		names := make(map[string]int)
+		for _, f := range families {
+			names[f.GetName()] = len(f.GetMetric())
+		}
+		Expect(names).To(Equal(map[string]int{
+			"libp2p_quic_dials_total":                1,
+			"libp2p_quic_dials_failed_total":         1,
+			"libp2p_quic_handshakes_accepted_total":  1,
+			"libp2p_quic_handshakes_rejected_total":  1,
+			"libp2p_quic_connections":                2,
+			"libp2p_quic_packets_sent_total":         1,
+			"libp2p_quic_packets_received_total":     1,
+			"libp2p_quic_handshake_duration_seconds": 2,
+		}))
+	})
+})
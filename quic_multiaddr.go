@@ -28,3 +28,37 @@ func toQuicMultiaddr(na net.Addr) (ma.Multiaddr, error) {
 func fromQuicMultiaddr(addr ma.Multiaddr) (net.Addr, error) {
 	return manet.ToNetAddr(addr.Decapsulate(quicMA))
 }
+
+var interfaceAddrs = net.InterfaceAddrs
+
+// expandUnspecified returns the QUIC multiaddrs of all network interfaces, if addr is an unspecified address.
+// Only addresses of the same IP family are returned. IPv6 link-local addresses are omitted, they can't be dialed without a zone.
+// If addr is not an unspecified address, it is returned as is.
+func expandUnspecified(addr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	netAddr, err := fromQuicMultiaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	udpAddr, ok := netAddr.(*net.UDPAddr)
+	if !ok || !udpAddr.IP.IsUnspecified() {
+		return []ma.Multiaddr{addr}, nil
+	}
+	isIPv4 := udpAddr.IP.To4() != nil
+	ifaceAddrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []ma.Multiaddr
+	for _, ifaceAddr := range ifaceAddrs {
+		ipNet, ok := ifaceAddr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != isIPv4 || (!isIPv4 && ipNet.IP.IsLinkLocalUnicast()) {
+			continue
+		}
+		maddr, err := toQuicMultiaddr(&net.UDPAddr{IP: ipNet.IP, Port: udpAddr.Port})
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, maddr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,218 @@
+package libp2pquic
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// ErrResourceLimitExceeded is returned when the ResourceManager refuses a new connection or stream.
+var ErrResourceLimitExceeded = errors.New("resource limit exceeded")
+
+// A ResourceManager accounts for the resources used by connections and streams,
+// and refuses new connections and streams when the resources are exhausted.
+type ResourceManager interface {
+	// OpenConnection reserves the resources of a new connection to a peer.
+	// memory is the number of bytes the connection may buffer.
+	// It is called after the handshake, once the peer was authenticated.
+	OpenConnection(dir network.Direction, p peer.ID, memory int) (ConnectionScope, error)
+}
+
+// A ConnectionScope accounts for the resources used by a connection and its streams.
+type ConnectionScope interface {
+	// OpenStream reserves the resources of a new stream.
+	// memory is the number of bytes the stream may buffer.
+	OpenStream(dir network.Direction, memory int) (StreamScope, error)
+	// Done releases the resources of the connection, and of all its streams.
+	// It is called when the connection is closed.
+	Done()
+}
+
+// A StreamScope accounts for the resources used by a stream.
+type StreamScope interface {
+	// Done releases the resources of the stream.
+	// It is called when the stream is closed in both directions, or reset.
+	Done()
+}
+
+// ResourceLimits are the limits enforced by the ResourceManager created by NewResourceManager.
+// A value of 0 means that the resource is not limited.
+type ResourceLimits struct {
+	// Conns and ConnsPerPeer limit the number of connections, in total and to a single peer.
+	Conns, ConnsPerPeer int
+	// Streams and StreamsPerPeer limit the number of streams, in total and with a single peer.
+	Streams, StreamsPerPeer int
+	// Memory and MemoryPerPeer limit the memory reserved by connections and streams, in total and for a single peer.
+	Memory, MemoryPerPeer int
+}
+
+// resourceUsage is the amount of resources in use.
+type resourceUsage struct {
+	conns, streams, memory int
+}
+
+func (u *resourceUsage) add(conns, streams, memory int) {
+	u.conns += conns
+	u.streams += streams
+	u.memory += memory
+}
+
+func (u *resourceUsage) isZero() bool {
+	return u.conns == 0 && u.streams == 0 && u.memory == 0
+}
+
+// exceeds says if adding the resources to the usage exceeds the limits.
+func (u *resourceUsage) exceeds(conns, streams, memory, connLimit, streamLimit, memoryLimit int) bool {
+	return (connLimit > 0 && u.conns+conns > connLimit) ||
+		(streamLimit > 0 && u.streams+streams > streamLimit) ||
+		(memoryLimit > 0 && u.memory+memory > memoryLimit)
+}
+
+type resourceManager struct {
+	limits ResourceLimits
+
+	mutex sync.Mutex
+	total resourceUsage
+	peers map[peer.ID]*resourceUsage
+}
+
+// NewResourceManager creates a ResourceManager that enforces per-peer and total limits.
+// New connections and streams fail with ErrResourceLimitExceeded when a limit would be exceeded.
+func NewResourceManager(limits ResourceLimits) ResourceManager {
+	return &resourceManager{
+		limits: limits,
+		peers:  make(map[peer.ID]*resourceUsage),
+	}
+}
+
+// reserve reserves resources for a peer, if they don't exceed the limits.
+func (m *resourceManager) reserve(p peer.ID, conns, streams, memory int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	usage, ok := m.peers[p]
+	if !ok {
+		usage = &resourceUsage{}
+	}
+	if m.total.exceeds(conns, streams, memory, m.limits.Conns, m.limits.Streams, m.limits.Memory) ||
+		usage.exceeds(conns, streams, memory, m.limits.ConnsPerPeer, m.limits.StreamsPerPeer, m.limits.MemoryPerPeer) {
+		return ErrResourceLimitExceeded
+	}
+	m.total.add(conns, streams, memory)
+	usage.add(conns, streams, memory)
+	m.peers[p] = usage
+	return nil
+}
+
+func (m *resourceManager) release(p peer.ID, conns, streams, memory int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.total.add(-conns, -streams, -memory)
+	usage := m.peers[p]
+	usage.add(-conns, -streams, -memory)
+	if usage.isZero() {
+		delete(m.peers, p)
+	}
+}
+
+func (m *resourceManager) OpenConnection(_ network.Direction, p peer.ID, memory int) (ConnectionScope, error) {
+	if err := m.reserve(p, 1, 0, memory); err != nil {
+		return nil, err
+	}
+	return &connectionScope{manager: m, peer: p, memory: memory}, nil
+}
+
+type connectionScope struct {
+	manager *resourceManager
+	peer    peer.ID
+	memory  int
+
+	mutex   sync.Mutex
+	done    bool
+	streams map[*streamScope]struct{}
+}
+
+func (s *connectionScope) OpenStream(_ network.Direction, memory int) (StreamScope, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.done {
+		return nil, ErrResourceLimitExceeded
+	}
+	if err := s.manager.reserve(s.peer, 0, 1, memory); err != nil {
+		return nil, err
+	}
+	str := &streamScope{conn: s, memory: memory}
+	if s.streams == nil {
+		s.streams = make(map[*streamScope]struct{})
+	}
+	s.streams[str] = struct{}{}
+	return str, nil
+}
+
+func (s *connectionScope) Done() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.done {
+		return
+	}
+	s.done = true
+	streams, memory := 0, s.memory
+	for str := range s.streams {
+		streams++
+		memory += str.memory
+	}
+	s.streams = nil
+	s.manager.release(s.peer, 1, streams, memory)
+}
+
+type streamScope struct {
+	conn   *connectionScope
+	memory int
+}
+
+func (s *streamScope) Done() {
+	s.conn.mutex.Lock()
+	defer s.conn.mutex.Unlock()
+
+	// the stream was already released, or the connection is done
+	if _, ok := s.conn.streams[s]; !ok {
+		return
+	}
+	delete(s.conn.streams, s)
+	s.conn.manager.release(s.conn.peer, 0, 1, s.memory)
+}
+
+// openScope reserves the resources of the connection, if a ResourceManager is configured.
+// The resources are released when the connection is closed.
+func (c *conn) openScope(rm ResourceManager, quicConf *quic.Config) error {
+	if rm == nil {
+		return nil
+	}
+	scope, err := rm.OpenConnection(c.direction, c.remotePeerID, int(quicConf.MaxReceiveConnectionFlowControlWindow))
+	if err != nil {
+		return err
+	}
+	c.scope = scope
+	c.streamMemory = int(quicConf.MaxReceiveStreamFlowControlWindow)
+	go func() {
+		<-c.sess.Context().Done()
+		scope.Done()
+	}()
+	return nil
+}
+
+// openStreamScope reserves the resources of a new stream.
+// It returns nil if no ResourceManager is configured.
+func (c *conn) openStreamScope(dir network.Direction) (StreamScope, error) {
+	if c.scope == nil {
+		return nil, nil
+	}
+	return c.scope.OpenStream(dir, c.streamMemory)
+}
@@ -0,0 +1,77 @@
+package libp2pquic
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resource Manager", func() {
+	const peerA, peerB = peer.ID("peer A"), peer.ID("peer B")
+
+	It("limits the number of connections", func() {
+		rm := NewResourceManager(ResourceLimits{Conns: 2, ConnsPerPeer: 1})
+		scope, err := rm.OpenConnection(network.DirInbound, peerA, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = rm.OpenConnection(network.DirOutbound, peerA, 0)
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+		_, err = rm.OpenConnection(network.DirOutbound, peerB, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = rm.OpenConnection(network.DirOutbound, peer.ID("peer C"), 0)
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+		scope.Done()
+		_, err = rm.OpenConnection(network.DirOutbound, peerA, 0)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("limits the number of streams", func() {
+		rm := NewResourceManager(ResourceLimits{StreamsPerPeer: 2})
+		scope, err := rm.OpenConnection(network.DirInbound, peerA, 0)
+		Expect(err).ToNot(HaveOccurred())
+		str, err := scope.OpenStream(network.DirInbound, 0)
+		Expect(err).ToNot(HaveOccurred())
+		otherScope, err := rm.OpenConnection(network.DirInbound, peerA, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = otherScope.OpenStream(network.DirOutbound, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = scope.OpenStream(network.DirOutbound, 0)
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+		str.Done()
+		str.Done() // releasing twice has no effect
+		_, err = scope.OpenStream(network.DirOutbound, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = scope.OpenStream(network.DirOutbound, 0)
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+	})
+
+	It("limits memory", func() {
+		rm := NewResourceManager(ResourceLimits{Memory: 1000, MemoryPerPeer: 600})
+		scope, err := rm.OpenConnection(network.DirInbound, peerA, 400)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = scope.OpenStream(network.DirInbound, 300)
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+		_, err = scope.OpenStream(network.DirInbound, 200)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = rm.OpenConnection(network.DirInbound, peerB, 500)
+		Expect(err).To(MatchError(ErrResourceLimitExceeded))
+		_, err = rm.OpenConnection(network.DirInbound, peerB, 400)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("releases the streams when the connection is done", func() {
+		rm := NewResourceManager(ResourceLimits{Streams: 1, Memory: 100})
+		scope, err := rm.OpenConnection(network.DirInbound, peerA, 50)
+		Expect(err).ToNot(HaveOccurred())
+		str, err := scope.OpenStream(network.DirInbound, 50)
+		Expect(err).ToNot(HaveOccurred())
+		scope.Done()
+		str.Done()
+		Expect(rm.(*resourceManager).peers).To(BeEmpty())
+		scope, err = rm.OpenConnection(network.DirInbound, peerA, 50)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = scope.OpenStream(network.DirInbound, 50)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,64 @@
+package libp2pquic
+
+import (
+	"context"
+	"runtime/trace"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// startTask starts a runtime/trace task, if runtime tracing is enabled,
+// and a span, if a tracer is configured.
+// The returned function ends the task and the span.
+func (c *config) startTask(ctx context.Context, name string) (context.Context, func()) {
+	var task *trace.Task
+	if c.runtimeTrace {
+		ctx, task = trace.NewTask(ctx, name)
+	}
+	var span Span
+	if c.tracer != nil {
+		ctx, span = c.tracer.Start(ctx, name)
+		ctx = context.WithValue(ctx, spanKey{}, span)
+	}
+	return ctx, func() {
+		if span != nil {
+			span.End()
+		}
+		if task != nil {
+			task.End()
+		}
+	}
+}
+
+// startRegion starts a runtime/trace region, if runtime tracing is enabled,
+// and a span, if a tracer is configured.
+// The returned function ends the region and the span.
+func (c *config) startRegion(ctx context.Context, name string) func() {
+	var region *trace.Region
+	if c.runtimeTrace {
+		region = trace.StartRegion(ctx, name)
+	}
+	var span Span
+	if c.tracer != nil {
+		_, span = c.tracer.Start(ctx, name)
+	}
+	return func() {
+		if span != nil {
+			span.End()
+		}
+		if region != nil {
+			region.End()
+		}
+	}
+}
+
+// tracePeer logs the peer ID in the "peer" category, if runtime tracing is enabled,
+// and sets it as the "peer" attribute of the span started by startTask, if any.
+func (c *config) tracePeer(ctx context.Context, p peer.ID) {
+	if c.runtimeTrace {
+		trace.Log(ctx, "peer", p.Pretty())
+	}
+	if span := spanFromContext(ctx); span != nil {
+		span.SetAttribute("peer", p.Pretty())
+	}
+}
@@ -0,0 +1,86 @@
+package libp2pquic
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// minSendRateBurst is the minimum burst of the send rate limit.
+// Every packet, including the overhead added in a private network, has to fit into the burst.
+const minSendRateBurst = maxPacketSize
+
+// sendRatePruneInterval is the interval at which the limiters of idle remote addresses are removed.
+const sendRatePruneInterval = time.Minute
+
+// sendRateConn limits the rate at which packets are sent to every remote address.
+// quic-go v0.12 doesn't pace packets, so WriteTo blocks until the packet may be sent.
+type sendRateConn struct {
+	net.PacketConn
+
+	limit rate.Limit
+	burst int
+
+	mutex     sync.Mutex
+	limiters  map[string]*sendRateLimiter
+	lastPrune time.Time
+}
+
+type sendRateLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+func newSendRateConn(conn net.PacketConn, limit rate.Limit, burst int) *sendRateConn {
+	return &sendRateConn{
+		PacketConn: conn,
+		limit:      limit,
+		burst:      burst,
+		limiters:   make(map[string]*sendRateLimiter),
+		lastPrune:  time.Now(),
+	}
+}
+
+func (c *sendRateConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if delay := c.reserve(len(b), addr); delay > 0 {
+		time.Sleep(delay)
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// reserve reserves n bytes for sending to addr, and returns how long the caller has to wait before sending.
+func (c *sendRateConn) reserve(n int, addr net.Addr) time.Duration {
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if now.Sub(c.lastPrune) > sendRatePruneInterval {
+		c.prune(now)
+	}
+	key := addr.String()
+	l, ok := c.limiters[key]
+	if !ok {
+		l = &sendRateLimiter{Limiter: rate.NewLimiter(c.limit, c.burst)}
+		c.limiters[key] = l
+	}
+	l.lastUsed = now
+	r := l.ReserveN(now, n)
+	if !r.OK() {
+		// the packet is larger than the burst, don't limit it
+		return 0
+	}
+	return r.DelayFrom(now)
+}
+
+// prune removes the limiters of remote addresses that were idle long enough to refill the burst.
+// A new limiter behaves the same way.
+func (c *sendRateConn) prune(now time.Time) {
+	refill := time.Duration(float64(c.burst) / float64(c.limit) * float64(time.Second))
+	for key, l := range c.limiters {
+		if now.Sub(l.lastUsed) > refill {
+			delete(c.limiters, key)
+		}
+	}
+	c.lastPrune = now
+}
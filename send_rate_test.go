@@ -0,0 +1,43 @@
+package libp2pquic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Send rate limit", func() {
+	It("limits the send rate per remote address", func() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		conf, err := newConfig(WithMaxSendRate(20000, 2000))
+		Expect(err).ToNot(HaveOccurred())
+		rconn := conf.wrap(conn)
+		addr1 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+		addr2 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1235}
+
+		start := time.Now()
+		// the first 2 packets use the burst, the other 8 packets take 400ms
+		for i := 0; i < 10; i++ {
+			_, err := rconn.WriteTo(make([]byte, 1000), addr1)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(time.Since(start)).To(BeNumerically(">", 350*time.Millisecond))
+
+		// the limit of the other address is independent
+		start = time.Now()
+		for i := 0; i < 2; i++ {
+			_, err := rconn.WriteTo(make([]byte, 1000), addr2)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+	})
+
+	It("requires the burst to fit a packet", func() {
+		_, err := newConfig(WithMaxSendRate(20000, 1000))
+		Expect(err).To(MatchError("send rate burst must be at least 1500 bytes"))
+	})
+})
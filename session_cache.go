@@ -0,0 +1,29 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A peerSessionCache stores the sessions of a single peer in a shared tls.ClientSessionCache.
+// The session key is derived from the TLS server name, which is the same for all libp2p peers.
+// Without the peer ID in the key, a session of one peer would be offered to all other peers.
+type peerSessionCache struct {
+	cache tls.ClientSessionCache
+	peer  peer.ID
+}
+
+var _ tls.ClientSessionCache = &peerSessionCache{}
+
+func (c *peerSessionCache) key(sessionKey string) string {
+	return string(c.peer) + "/" + sessionKey
+}
+
+func (c *peerSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	return c.cache.Get(c.key(sessionKey))
+}
+
+func (c *peerSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.cache.Put(c.key(sessionKey), cs)
+}
@@ -0,0 +1,152 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/time/rate"
+)
+
+// listenUDP opens a UDP socket, applies the socket options set in the config,
+// and wraps it with the configured middlewares.
+// If a packet conn factory is configured, it is used instead of opening a UDP socket.
+func (c *config) listenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	var conn net.PacketConn
+	var err error
+	if c.packetConnFactory != nil {
+		conn, err = c.packetConnFactory(network, laddr)
+	} else {
+		conn, err = c.listenUDPSocket(network, laddr, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.wrap(conn), nil
+}
+
+// listenUDPShards opens n UDP sockets listening on the same address, using SO_REUSEPORT,
+// and wraps them with the configured middlewares.
+// If the port of laddr is 0, a random port is chosen for the first socket.
+func (c *config) listenUDPShards(network string, laddr *net.UDPAddr, n int) ([]net.PacketConn, error) {
+	if c.packetConnFactory != nil {
+		return nil, errors.New("listener shards can't be used with a packet conn factory")
+	}
+	conns := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := c.listenUDPSocket(network, laddr, true)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		// all other shards need to listen on the port chosen for the first one
+		laddr = conn.LocalAddr().(*net.UDPAddr)
+		conns = append(conns, conn)
+	}
+	for i, conn := range conns {
+		conns[i] = c.wrap(conn)
+	}
+	return conns, nil
+}
+
+// wrap wraps conn with the configured middlewares.
+// In a private network, the packets are encrypted first, so the middlewares see the unencrypted packets.
+// Packets are counted as they are sent and received on the socket.
+// The send rate is limited after encryption, so the limit includes the overhead of the private network.
+// Received packets are reported to the packet watchers after decryption, so only members of the private network are reported.
+// STUN isn't encrypted, so STUN responses are received before decryption.
+func (c *config) wrap(conn net.PacketConn) net.PacketConn {
+	if c.metrics != nil {
+		conn = &metricsConn{PacketConn: conn, metrics: c.metrics}
+	}
+	if c.maxSendRate > 0 {
+		conn = newSendRateConn(conn, rate.Limit(c.maxSendRate), c.sendRateBurst)
+	}
+	if c.stun != nil {
+		conn = c.stun.wrap(conn)
+	}
+	if c.psk != nil {
+		conn = newPSKConn(conn, c.psk)
+	}
+	conn = &packetWatchConn{PacketConn: conn, watchers: c.packetWatchers}
+	for _, m := range c.packetConnMiddlewares {
+		conn = m(conn)
+	}
+	return conn
+}
+
+func (c *config) listenUDPSocket(network string, laddr *net.UDPAddr, reusePort bool) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: c.control(reusePort)}
+	conn, err := lc.ListenPacket(context.Background(), network, laddr.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setBufferSizes(conn.(*net.UDPConn)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	recordDstAddrs := c.dstAddrs != nil && laddr.IP.IsUnspecified()
+	if c.receiveBatchSize > 1 || recordDstAddrs {
+		size := c.receiveBatchSize
+		if size == 0 {
+			size = 1
+		}
+		bconn := newBatchConn(network, conn.(*net.UDPConn), size)
+		if recordDstAddrs {
+			bconn.recordDstAddrs(c.dstAddrs)
+		}
+		return bconn, nil
+	}
+	return conn, nil
+}
+
+// setBufferSizes sets the sizes of the receive and send buffers, if configured.
+// The kernel might allocate smaller buffers than requested, in which case a warning is logged.
+func (c *config) setBufferSizes(conn *net.UDPConn) error {
+	if c.receiveBufferSize > 0 {
+		if err := conn.SetReadBuffer(c.receiveBufferSize); err != nil {
+			return err
+		}
+	}
+	if c.sendBufferSize > 0 {
+		if err := conn.SetWriteBuffer(c.sendBufferSize); err != nil {
+			return err
+		}
+	}
+	if c.receiveBufferSize == 0 && c.sendBufferSize == 0 {
+		return nil
+	}
+	receiveBufferSize, sendBufferSize, err := getBufferSizes(conn)
+	if err != nil {
+		// not supported on this platform
+		return nil
+	}
+	if receiveBufferSize < c.receiveBufferSize {
+		c.logger.Warnf("requested a receive buffer of %d bytes, but the kernel only allocated %d bytes", c.receiveBufferSize, receiveBufferSize)
+	}
+	if sendBufferSize < c.sendBufferSize {
+		c.logger.Warnf("requested a send buffer of %d bytes, but the kernel only allocated %d bytes", c.sendBufferSize, sendBufferSize)
+	}
+	return nil
+}
+
+// control returns the function that sets the socket options, before the socket is bound.
+func (c *config) control(reusePort bool) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		var err error
+		if cerr := rc.Control(func(fd uintptr) {
+			if err = c.setSocketOptions(network, fd); err != nil {
+				return
+			}
+			if reusePort {
+				err = setReusePort(fd)
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
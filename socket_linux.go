@@ -0,0 +1,82 @@
+package libp2pquic
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func checkListenerShards(int) error {
+	return nil
+}
+
+func setReusePort(fd uintptr) error {
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		return os.NewSyscallError("setsockopt SO_REUSEPORT", err)
+	}
+	return nil
+}
+
+func checkVRF(name string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return fmt.Errorf("VRF device %s: %s", name, err)
+	}
+	return nil
+}
+
+func checkDSCP() error {
+	return nil
+}
+
+func (c *config) setSocketOptions(network string, fd uintptr) error {
+	if c.busyPoll > 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BUSY_POLL, c.busyPoll); err != nil {
+			return os.NewSyscallError("setsockopt SO_BUSY_POLL", err)
+		}
+	}
+	if c.dscp != nil {
+		// the DSCP is stored in the upper 6 bits of the traffic class
+		tos := int(*c.dscp) << 2
+		switch network {
+		case "udp4":
+			if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos); err != nil {
+				return os.NewSyscallError("setsockopt IP_TOS", err)
+			}
+		case "udp6":
+			if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); err != nil {
+				return os.NewSyscallError("setsockopt IPV6_TCLASS", err)
+			}
+		}
+	}
+	if c.vrf != "" {
+		if err := unix.BindToDevice(int(fd), c.vrf); err != nil {
+			return os.NewSyscallError("setsockopt SO_BINDTODEVICE", err)
+		}
+	}
+	return nil
+}
+
+// getBufferSizes returns the sizes of the receive and send buffers that are usable for packets.
+func getBufferSizes(conn *net.UDPConn) (receive, send int, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var serr error
+	if err := rawConn.Control(func(fd uintptr) {
+		receive, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		if serr != nil {
+			return
+		}
+		send, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+	}); err != nil {
+		return 0, 0, err
+	}
+	if serr != nil {
+		return 0, 0, os.NewSyscallError("getsockopt", serr)
+	}
+	// Linux doubles the requested size to make room for bookkeeping overhead, see socket(7).
+	return receive / 2, send / 2, nil
+}
@@ -0,0 +1,183 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net"
+	"os"
+	"syscall"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Socket Options", func() {
+	createKey := func() ic.PrivKey {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		return key
+	}
+
+	getSockOpt := func(conn net.PacketConn, level, opt int) int {
+		rawConn, err := conn.(syscall.Conn).SyscallConn()
+		Expect(err).ToNot(HaveOccurred())
+		var val int
+		var serr error
+		Expect(rawConn.Control(func(fd uintptr) {
+			val, serr = unix.GetsockoptInt(int(fd), level, opt)
+		})).To(Succeed())
+		Expect(serr).ToNot(HaveOccurred())
+		return val
+	}
+
+	It("sets SO_BUSY_POLL", func() {
+		conf, err := newConfig(WithBusyPoll(50))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := conf.listenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if opErr, ok := err.(*net.OpError); ok && os.IsPermission(opErr.Err) {
+			Skip("setting SO_BUSY_POLL requires CAP_NET_ADMIN")
+		}
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(getSockOpt(conn, unix.SOL_SOCKET, unix.SO_BUSY_POLL)).To(Equal(50))
+
+		// make sure that the transport still works
+		serverKey := createKey()
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(serverKey, WithBusyPoll(50))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			defer GinkgoRecover()
+			_, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+		}()
+		clientTransport, err := NewTransport(createKey(), WithBusyPoll(50))
+		Expect(err).ToNot(HaveOccurred())
+		c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Close()).To(Succeed())
+	})
+
+	It("binds to a VRF device", func() {
+		// We can't rely on a VRF device being configured on the test machine.
+		// Binding to the loopback device uses the same mechanism.
+		conf, err := newConfig(WithVRF("lo"))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := conf.listenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if opErr, ok := err.(*net.OpError); ok && os.IsPermission(opErr.Err) {
+			Skip("setting SO_BINDTODEVICE requires CAP_NET_RAW")
+		}
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		rawConn, err := conn.(syscall.Conn).SyscallConn()
+		Expect(err).ToNot(HaveOccurred())
+		var device string
+		var serr error
+		Expect(rawConn.Control(func(fd uintptr) {
+			device, serr = unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+		})).To(Succeed())
+		Expect(serr).ToNot(HaveOccurred())
+		Expect(device).To(Equal("lo"))
+	})
+
+	It("errors when the VRF device doesn't exist", func() {
+		_, err := NewTransport(createKey(), WithVRF("foobar-does-not-exist"))
+		Expect(err).To(MatchError(ContainSubstring("VRF device foobar-does-not-exist")))
+	})
+
+	It("sets the socket buffer sizes", func() {
+		logger := &recordingLogger{}
+		conf, err := newConfig(WithSocketBufferSizes(64<<10, 32<<10), WithLogger(logger))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := conf.listenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(getSockOpt(conn, unix.SOL_SOCKET, unix.SO_RCVBUF)).To(Equal(2 * 64 << 10))
+		Expect(getSockOpt(conn, unix.SOL_SOCKET, unix.SO_SNDBUF)).To(Equal(2 * 32 << 10))
+		Expect(logger.Messages()).To(BeEmpty())
+	})
+
+	It("warns when the kernel allocates smaller buffers than requested", func() {
+		logger := &recordingLogger{}
+		conf, err := newConfig(WithSocketBufferSizes(1<<30, 0), WithLogger(logger))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := conf.listenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		if getSockOpt(conn, unix.SOL_SOCKET, unix.SO_RCVBUF) >= 1<<30 {
+			Skip("the kernel allows receive buffers of 1 GB")
+		}
+		Expect(logger.Messages()).To(HaveLen(1))
+		Expect(logger.Messages()[0]).To(ContainSubstring("requested a receive buffer of 1073741824 bytes"))
+	})
+
+	It("accepts connections on sharded listeners", func() {
+		serverKey := createKey()
+		serverID, err := peer.IDFromPrivateKey(serverKey)
+		Expect(err).ToNot(HaveOccurred())
+		serverTransport, err := NewTransport(serverKey, WithListenerShards(4))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		shards := ln.(*listener).quicListener.(*shardedListener).listeners
+		Expect(shards).To(HaveLen(4))
+		for _, shard := range shards {
+			Expect(shard.Addr()).To(Equal(ln.Addr()))
+		}
+
+		const num = 10
+		accepted := make(chan struct{}, num)
+		go func() {
+			defer GinkgoRecover()
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				accepted <- struct{}{}
+			}
+		}()
+		// every client uses a different port, so the connections are distributed between the shards
+		for i := 0; i < num; i++ {
+			clientTransport, err := NewTransport(createKey())
+			Expect(err).ToNot(HaveOccurred())
+			c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+			Expect(err).ToNot(HaveOccurred())
+			defer c.Close()
+		}
+		for i := 0; i < num; i++ {
+			Eventually(accepted).Should(Receive())
+		}
+	})
+
+	It("sets the DSCP", func() {
+		conf, err := newConfig(WithDSCP(46))
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := conf.listenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(getSockOpt(conn, unix.IPPROTO_IP, unix.IP_TOS)).To(Equal(46 << 2))
+
+		conn6, err := conf.listenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+		if err != nil {
+			Skip("IPv6 not available")
+		}
+		defer conn6.Close()
+		Expect(getSockOpt(conn6, unix.IPPROTO_IPV6, unix.IPV6_TCLASS)).To(Equal(46 << 2))
+	})
+})
@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+package libp2pquic
+
+import (
+	"errors"
+	"net"
+)
+
+func checkListenerShards(n int) error {
+	if n > 1 {
+		return errors.New("listener shards are only supported on Linux")
+	}
+	return nil
+}
+
+func setReusePort(uintptr) error {
+	return errors.New("SO_REUSEPORT is only supported on Linux")
+}
+
+func checkVRF(string) error {
+	return errors.New("binding to a VRF device is only supported on Linux")
+}
+
+func checkDSCP() error {
+	return errors.New("setting the DSCP is only supported on Linux")
+}
+
+func (c *config) setSocketOptions(network string, fd uintptr) error {
+	// SO_BUSY_POLL and SO_BINDTODEVICE are only available on Linux.
+	return nil
+}
+
+func getBufferSizes(*net.UDPConn) (int, int, error) {
+	return 0, 0, errors.New("reading the buffer sizes is only supported on Linux")
+}
@@ -0,0 +1,74 @@
+package libp2pquic
+
+import (
+	"hash/fnv"
+	"net"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A SocketSelectionStrategy decides which socket a dial uses, if there are multiple eligible sockets.
+// This is the case when there are multiple listeners of the same address family that dials can use,
+// see WithoutListenSocketReuse. The sockets of sharded listeners are never used for dialing, see WithListenerShards.
+type SocketSelectionStrategy uint8
+
+const (
+	// SelectFirstSocket uses the same socket for all dials, the one with the lowest local address.
+	// This keeps the number of NAT mappings low, but puts the load of all dialed connections on a single socket.
+	SelectFirstSocket SocketSelectionStrategy = iota
+	// SelectRoundRobin uses the sockets in turn.
+	// This distributes new connections evenly, but connections to the same peer use different sockets,
+	// so the peer sees us at a different address for every connection.
+	SelectRoundRobin
+	// SelectLeastLoaded uses the socket that is used by the fewest open connections, both dialed and accepted.
+	// This balances the load even if connections have different lifetimes,
+	// but counting the connections makes selecting a socket more expensive.
+	SelectLeastLoaded
+	// SelectByPeerID hashes the peer ID to select a socket, such that all dials of a peer use the same socket.
+	// The peer sees us at a stable address, which helps NAT traversal, but the load is only balanced across many peers.
+	// Adding or removing a listener changes the socket of most peers.
+	SelectByPeerID
+)
+
+// selectSocket selects the socket used by a dial of p from the eligible sockets, sorted by their local address.
+// It must be called with the mutex held.
+func (c *connManager) selectSocket(sockets []net.PacketConn, p peer.ID) net.PacketConn {
+	switch c.config.socketSelection {
+	case SelectRoundRobin:
+		conn := sockets[c.nextSocket%uint64(len(sockets))]
+		c.nextSocket++
+		return conn
+	case SelectLeastLoaded:
+		if c.socketLoad == nil {
+			return sockets[0]
+		}
+		conn, load := sockets[0], c.socketLoad(sockets[0])
+		for _, s := range sockets[1:] {
+			if l := c.socketLoad(s); l < load {
+				conn, load = s, l
+			}
+		}
+		return conn
+	case SelectByPeerID:
+		h := fnv.New64a()
+		h.Write([]byte(p))
+		return sockets[h.Sum64()%uint64(len(sockets))]
+	default:
+		return sockets[0]
+	}
+}
+
+// socketLoad returns the number of open connections using the socket.
+func (t *transport) socketLoad(socket net.PacketConn) int {
+	laddr, err := toQuicMultiaddr(socket.LocalAddr())
+	if err != nil {
+		return 0
+	}
+	var n int
+	for _, c := range t.conns.all() {
+		if c.localMultiaddr.Equal(laddr) && !c.IsClosed() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,97 @@
+package libp2pquic
+
+import (
+	"net"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Socket Selection", func() {
+	var sockets []net.PacketConn
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+
+	newConnManager := func(strategy SocketSelectionStrategy) *connManager {
+		conf, err := newConfig(WithSocketSelectionStrategy(strategy))
+		Expect(err).ToNot(HaveOccurred())
+		m := &connManager{config: conf}
+		for _, s := range sockets {
+			m.addListenConn("udp4", s)
+		}
+		return m
+	}
+
+	BeforeEach(func() {
+		sockets = nil
+		for i := 0; i < 3; i++ {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			sockets = append(sockets, conn)
+		}
+	})
+
+	AfterEach(func() {
+		for _, s := range sockets {
+			s.Close()
+		}
+	})
+
+	It("uses the same socket by default", func() {
+		m := newConnManager(SelectFirstSocket)
+		conn, err := m.GetConnForAddr("udp4", raddr, "")
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 5; i++ {
+			c, err := m.GetConnForAddr("udp4", raddr, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c).To(Equal(conn))
+		}
+	})
+
+	It("distributes dials using round-robin", func() {
+		m := newConnManager(SelectRoundRobin)
+		used := make(map[net.PacketConn]int)
+		for i := 0; i < 3*len(sockets); i++ {
+			conn, err := m.GetConnForAddr("udp4", raddr, "")
+			Expect(err).ToNot(HaveOccurred())
+			used[conn]++
+		}
+		Expect(used).To(HaveLen(len(sockets)))
+		for _, s := range sockets {
+			Expect(used[s]).To(Equal(3))
+		}
+	})
+
+	It("pins a peer to a socket, when hashing the peer ID", func() {
+		m := newConnManager(SelectByPeerID)
+		used := make(map[net.PacketConn]struct{})
+		for i := 0; i < 100; i++ {
+			p := peer.ID(string(rune('a' + i%26)))
+			conn, err := m.GetConnForAddr("udp4", raddr, p)
+			Expect(err).ToNot(HaveOccurred())
+			for j := 0; j < 3; j++ {
+				c, err := m.GetConnForAddr("udp4", raddr, p)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c).To(Equal(conn))
+			}
+			used[conn] = struct{}{}
+		}
+		// different peers use different sockets
+		Expect(len(used)).To(BeNumerically(">", 1))
+	})
+
+	It("uses the least loaded socket", func() {
+		m := newConnManager(SelectLeastLoaded)
+		load := map[net.PacketConn]int{sockets[0]: 3, sockets[1]: 1, sockets[2]: 2}
+		m.socketLoad = func(s net.PacketConn) int { return load[s] }
+		conn, err := m.GetConnForAddr("udp4", raddr, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn).To(Equal(sockets[1]))
+	})
+
+	It("refuses unknown strategies", func() {
+		_, err := newConfig(WithSocketSelectionStrategy(42))
+		Expect(err).To(MatchError("unknown socket selection strategy: 42"))
+	})
+})
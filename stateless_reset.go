@@ -0,0 +1,42 @@
+package libp2pquic
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// statelessResetKeyLen is the minimum length of the stateless reset key.
+const statelessResetKeyLen = 32
+
+// loadOrCreateStatelessResetKey reads the stateless reset key from the file at path.
+// If the file doesn't exist, a new key is generated and written to the file.
+func loadOrCreateStatelessResetKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(key) < statelessResetKeyLen {
+			return nil, fmt.Errorf("stateless reset key in %s too short: %d bytes", path, len(key))
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key = make([]byte, statelessResetKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,61 @@
+package libp2pquic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stateless Reset Key", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "libp2pquic")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("sets the key", func() {
+		key := bytes.Repeat([]byte{42}, 32)
+		conf, err := newConfig(WithStatelessResetKey(key))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.quicConfig.StatelessResetKey).To(Equal(key))
+		// the default config is not modified
+		Expect(quicConfig.StatelessResetKey).To(BeNil())
+	})
+
+	It("rejects short keys", func() {
+		_, err := newConfig(WithStatelessResetKey(make([]byte, 16)))
+		Expect(err).To(MatchError("stateless reset key too short: 16 bytes, need at least 32 bytes"))
+	})
+
+	It("creates a key file, and reuses the key", func() {
+		path := filepath.Join(dir, "reset.key")
+		conf, err := newConfig(WithStatelessResetKeyFile(path))
+		Expect(err).ToNot(HaveOccurred())
+		key := conf.quicConfig.StatelessResetKey
+		Expect(key).To(HaveLen(32))
+		fi, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		conf, err = newConfig(WithStatelessResetKeyFile(path))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.quicConfig.StatelessResetKey).To(Equal(key))
+	})
+
+	It("rejects key files containing a short key", func() {
+		path := filepath.Join(dir, "reset.key")
+		Expect(ioutil.WriteFile(path, []byte("foobar"), 0600)).To(Succeed())
+		_, err := newConfig(WithStatelessResetKeyFile(path))
+		Expect(err).To(MatchError(ContainSubstring("too short")))
+	})
+})
@@ -0,0 +1,62 @@
+package libp2pquic
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ConnStatsSnapshot contains statistics about a connection, taken at a single point in time.
+type ConnStatsSnapshot struct {
+	RemotePeer      peer.ID
+	LocalMultiaddr  ma.Multiaddr
+	RemoteMultiaddr ma.Multiaddr
+	// Opened is the time when the handshake completed.
+	Opened time.Time
+
+	// StreamsOpened is the number of streams opened by us, StreamsAccepted the number of streams accepted from the peer.
+	StreamsOpened   uint64
+	StreamsAccepted uint64
+	// BytesSent and BytesReceived count the stream data written to and read from streams.
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// connStats holds the counters of a connection.
+// It must be the first field in the conn, to guarantee 64-bit alignment of the counters for atomic access.
+type connStats struct {
+	streamsOpened   uint64
+	streamsAccepted uint64
+	bytesSent       uint64
+	bytesReceived   uint64
+}
+
+// Stats returns a snapshot of the statistics of the connection.
+func (c *conn) Stats() ConnStatsSnapshot {
+	return ConnStatsSnapshot{
+		RemotePeer:      c.remotePeerID,
+		LocalMultiaddr:  c.localMultiaddr,
+		RemoteMultiaddr: c.remoteMultiaddr,
+		Opened:          c.opened,
+		StreamsOpened:   atomic.LoadUint64(&c.stats.streamsOpened),
+		StreamsAccepted: atomic.LoadUint64(&c.stats.streamsAccepted),
+		BytesSent:       atomic.LoadUint64(&c.stats.bytesSent),
+		BytesReceived:   atomic.LoadUint64(&c.stats.bytesReceived),
+	}
+}
+
+// SnapshotStats returns the statistics of all open connections.
+func (t *transport) SnapshotStats() []ConnStatsSnapshot {
+	conns := t.conns.all()
+	stats := make([]ConnStatsSnapshot, 0, len(conns))
+	for _, c := range conns {
+		// skip connections that were closed after we obtained the list
+		if c.IsClosed() {
+			continue
+		}
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
@@ -1,19 +1,169 @@
 package libp2pquic
 
 import (
+	"io"
+	"sync"
+	"sync/atomic"
+
 	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/network"
 
 	quic "github.com/lucas-clemente/quic-go"
 )
 
+// StreamStatus is the status of a stream.
+type StreamStatus uint8
+
+const (
+	// StreamOpen means that the stream is open in both directions.
+	StreamOpen StreamStatus = iota
+	// StreamHalfClosedLocal means that we closed the stream for writing.
+	StreamHalfClosedLocal
+	// StreamHalfClosedRemote means that the peer closed the stream for writing.
+	StreamHalfClosedRemote
+)
+
+func (s StreamStatus) String() string {
+	switch s {
+	case StreamOpen:
+		return "open"
+	case StreamHalfClosedLocal:
+		return "half-closed (local)"
+	case StreamHalfClosedRemote:
+		return "half-closed (remote)"
+	default:
+		return "unknown stream status"
+	}
+}
+
+// StreamState describes the state of a stream.
+type StreamState struct {
+	ID        quic.StreamID
+	Direction network.Direction
+	Status    StreamStatus
+
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
 type stream struct {
+	// must be the first fields, to guarantee 64-bit alignment for atomic access
+	bytesSent     uint64
+	bytesReceived uint64
+
 	quic.Stream
+
+	conn      *conn
+	direction network.Direction
+	// scope accounts for the resources of the stream. It is nil if no ResourceManager is configured.
+	scope StreamScope
+
+	mutex        sync.Mutex
+	localClosed  bool
+	remoteClosed bool
+	// done is closed once both directions are closed, and the stream was released
+	done chan struct{}
 }
 
 var _ mux.MuxedStream = &stream{}
 
+func newStream(qstr quic.Stream, c *conn, dir network.Direction, scope StreamScope) *stream {
+	str := &stream{Stream: qstr, conn: c, direction: dir, scope: scope, done: make(chan struct{})}
+	c.addStream(str)
+	go str.watch()
+	return str
+}
+
+// watch releases the stream once quic-go is done with it, even if the application never observes
+// the end of both directions.
+// The write side is finished when the context of the stream is cancelled, which happens when it is closed or reset
+// (by either peer), or when the connection is closed. The read side can't be used any more once the connection is closed.
+func (s *stream) watch() {
+	select {
+	case <-s.Stream.Context().Done():
+		s.closed(true, false)
+	case <-s.done:
+		return
+	}
+	select {
+	case <-s.conn.sess.Context().Done():
+		s.closed(true, true)
+	case <-s.done:
+	}
+}
+
+func (s *stream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	atomic.AddUint64(&s.bytesReceived, uint64(n))
+	atomic.AddUint64(&s.conn.stats.bytesReceived, uint64(n))
+	if err == io.EOF {
+		s.closed(false, true)
+	} else if isCanceled(err) {
+		s.closed(true, true)
+	}
+	return n, err
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	n, err := s.Stream.Write(b)
+	atomic.AddUint64(&s.bytesSent, uint64(n))
+	atomic.AddUint64(&s.conn.stats.bytesSent, uint64(n))
+	if isCanceled(err) {
+		s.closed(true, true)
+	}
+	return n, err
+}
+
+func (s *stream) Close() error {
+	s.closed(true, false)
+	return s.Stream.Close()
+}
+
 func (s *stream) Reset() error {
 	s.Stream.CancelRead(0)
 	s.Stream.CancelWrite(0)
+	s.closed(true, true)
 	return nil
 }
+
+// closed marks the stream as closed for writing (local) and / or reading (remote).
+// Once both directions are closed, the stream is removed from the connection, and its resources are released.
+func (s *stream) closed(local, remote bool) {
+	s.mutex.Lock()
+	wasDone := s.localClosed && s.remoteClosed
+	s.localClosed = s.localClosed || local
+	s.remoteClosed = s.remoteClosed || remote
+	done := !wasDone && s.localClosed && s.remoteClosed
+	s.mutex.Unlock()
+	if done {
+		close(s.done)
+		s.conn.removeStream(s)
+		if s.scope != nil {
+			s.scope.Done()
+		}
+	}
+}
+
+func (s *stream) state() StreamState {
+	s.mutex.Lock()
+	status := StreamOpen
+	if s.localClosed {
+		status = StreamHalfClosedLocal
+	} else if s.remoteClosed {
+		status = StreamHalfClosedRemote
+	}
+	s.mutex.Unlock()
+	return StreamState{
+		ID:            s.StreamID(),
+		Direction:     s.direction,
+		Status:        status,
+		BytesSent:     atomic.LoadUint64(&s.bytesSent),
+		BytesReceived: atomic.LoadUint64(&s.bytesReceived),
+	}
+}
+
+// isCanceled says if an error was caused by a cancelation of the stream, by either peer.
+func isCanceled(err error) bool {
+	serr, ok := err.(quic.StreamError)
+	return ok && serr.Canceled()
+}
@@ -0,0 +1,279 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrNoSTUNServers is returned by DiscoverPublicAddr if no STUN servers were configured using WithSTUNServers.
+var ErrNoSTUNServers = errors.New("no STUN servers configured")
+
+const (
+	stunHeaderLen          = 20
+	stunMagicCookie        = 0x2112a442
+	stunBindingRequest     = 0x0001
+	stunBindingSuccess     = 0x0101
+	stunAttrMappedAddr     = 0x0001
+	stunAttrXORMappedAddr  = 0x0020
+	stunRetransmitInterval = 500 * time.Millisecond
+	// stunAttempts is the number of requests sent to a STUN server, before trying the next one.
+	stunAttempts = 3
+)
+
+type stunTransactionID [12]byte
+
+// The stunClient sends STUN binding requests from the sockets of the transport,
+// and receives the responses before they reach quic-go.
+type stunClient struct {
+	servers []string
+
+	mutex sync.Mutex
+	// conns are the sockets of the transport, by local address.
+	// Listener shards share the same local address, any one of them can be used.
+	conns        map[string]*stunConn
+	transactions map[stunTransactionID]chan *net.UDPAddr
+}
+
+func newSTUNClient(servers []string) *stunClient {
+	return &stunClient{
+		servers:      servers,
+		conns:        make(map[string]*stunConn),
+		transactions: make(map[stunTransactionID]chan *net.UDPAddr),
+	}
+}
+
+// A stunConn delivers STUN responses to the stunClient, and passes all other packets on.
+// STUN is sent unencrypted, so in a private network, the stunConn is below the encryption.
+type stunConn struct {
+	net.PacketConn
+	client *stunClient
+}
+
+func (c *stunClient) wrap(conn net.PacketConn) net.PacketConn {
+	sc := &stunConn{PacketConn: conn, client: c}
+	c.mutex.Lock()
+	c.conns[conn.LocalAddr().String()] = sc
+	c.mutex.Unlock()
+	return sc
+}
+
+func (c *stunConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || !c.client.handlePacket(b[:n]) {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *stunConn) Close() error {
+	c.client.mutex.Lock()
+	if c.client.conns[c.LocalAddr().String()] == c {
+		delete(c.client.conns, c.LocalAddr().String())
+	}
+	c.client.mutex.Unlock()
+	return c.PacketConn.Close()
+}
+
+// handlePacket delivers a STUN response to the transaction waiting for it.
+// It returns false if the packet isn't a response to one of our requests.
+func (c *stunClient) handlePacket(b []byte) bool {
+	if len(b) < stunHeaderLen || b[0]&0xc0 != 0 || binary.BigEndian.Uint32(b[4:8]) != stunMagicCookie {
+		return false
+	}
+	var id stunTransactionID
+	copy(id[:], b[8:20])
+	c.mutex.Lock()
+	ch, ok := c.transactions[id]
+	c.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	if binary.BigEndian.Uint16(b[0:2]) != stunBindingSuccess {
+		// an error response, let the request time out
+		return true
+	}
+	if addr := parseSTUNMappedAddr(b, id); addr != nil {
+		select {
+		case ch <- addr:
+		default:
+		}
+	}
+	return true
+}
+
+// discover sends binding requests from the socket bound to laddr to the STUN servers, until one of them responds.
+func (c *stunClient) discover(ctx context.Context, laddr *net.UDPAddr) (*net.UDPAddr, error) {
+	c.mutex.Lock()
+	conn, ok := c.conns[laddr.String()]
+	c.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no socket bound to %s", laddr)
+	}
+	network := "udp6"
+	if laddr.IP.To4() != nil {
+		network = "udp4"
+	}
+	var errs []string
+	for _, server := range c.servers {
+		addr, err := c.request(ctx, conn, network, server)
+		if err == nil {
+			return addr, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", server, err))
+	}
+	return nil, fmt.Errorf("STUN failed: %v", errs)
+}
+
+func (c *stunClient) request(ctx context.Context, conn *stunConn, network, server string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var serverAddr *net.UDPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == (network == "udp4") {
+			serverAddr, err = net.ResolveUDPAddr(network, net.JoinHostPort(ip.String(), port))
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if serverAddr == nil {
+		return nil, fmt.Errorf("no %s address", network)
+	}
+
+	var id stunTransactionID
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	ch := make(chan *net.UDPAddr, 1)
+	c.mutex.Lock()
+	c.transactions[id] = ch
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.transactions, id)
+		c.mutex.Unlock()
+	}()
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	copy(req[8:20], id[:])
+	ticker := time.NewTicker(stunRetransmitInterval)
+	defer ticker.Stop()
+	for attempt := 0; ; attempt++ {
+		if attempt == stunAttempts {
+			return nil, errors.New("timeout")
+		}
+		if _, err := conn.PacketConn.WriteTo(req, serverAddr); err != nil {
+			return nil, err
+		}
+		select {
+		case addr := <-ch:
+			return addr, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// parseSTUNMappedAddr parses the (XOR-)MAPPED-ADDRESS attribute of a binding response.
+func parseSTUNMappedAddr(b []byte, id stunTransactionID) *net.UDPAddr {
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if stunHeaderLen+length > len(b) {
+		return nil
+	}
+	attrs := b[stunHeaderLen : stunHeaderLen+length]
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		l := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+l > len(attrs) {
+			return nil
+		}
+		value := attrs[4 : 4+l]
+		switch typ {
+		case stunAttrXORMappedAddr:
+			if addr := parseSTUNAddr(value); addr != nil {
+				var key [16]byte
+				binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+				copy(key[4:], id[:])
+				addr.Port ^= stunMagicCookie >> 16
+				for i := range addr.IP {
+					addr.IP[i] ^= key[i]
+				}
+				return addr
+			}
+		case stunAttrMappedAddr:
+			mapped = parseSTUNAddr(value)
+		}
+		// attributes are padded to a multiple of 4 bytes
+		l = (l + 3) &^ 3
+		if 4+l > len(attrs) {
+			break
+		}
+		attrs = attrs[4+l:]
+	}
+	return mapped
+}
+
+// parseSTUNAddr parses the value of an address attribute.
+func parseSTUNAddr(b []byte) *net.UDPAddr {
+	if len(b) < 4 {
+		return nil
+	}
+	var ipLen int
+	switch b[1] {
+	case 0x01:
+		ipLen = net.IPv4len
+	case 0x02:
+		ipLen = net.IPv6len
+	default:
+		return nil
+	}
+	if len(b) < 4+ipLen {
+		return nil
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, b[4:4+ipLen])
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(b[2:4]))}
+}
+
+// DiscoverPublicAddr discovers the public address of the socket bound to laddr, using the STUN servers.
+func (t *transport) DiscoverPublicAddr(ctx context.Context, laddr ma.Multiaddr) (ma.Multiaddr, error) {
+	if t.config.stun == nil {
+		return nil, ErrNoSTUNServers
+	}
+	addr, err := fromQuicMultiaddr(laddr)
+	if err != nil {
+		return nil, err
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("not a UDP address: %s", addr)
+	}
+	publicAddr, err := t.config.stun.discover(ctx, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return toQuicMultiaddr(publicAddr)
+}
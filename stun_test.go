@@ -0,0 +1,79 @@
+package libp2pquic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("STUN", func() {
+	var key ic.PrivKey
+
+	BeforeEach(func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err = ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// runSTUNServer runs a STUN server that responds to binding requests with the XOR-MAPPED-ADDRESS of the client.
+	runSTUNServer := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			b := make([]byte, 1500)
+			for {
+				n, addr, err := conn.ReadFromUDP(b)
+				if err != nil {
+					return
+				}
+				if n < 20 || binary.BigEndian.Uint16(b[0:2]) != 0x0001 {
+					continue
+				}
+				resp := make([]byte, 32)
+				binary.BigEndian.PutUint16(resp[0:2], 0x0101)
+				binary.BigEndian.PutUint16(resp[2:4], 12)
+				copy(resp[4:20], b[4:20]) // magic cookie and transaction ID
+				binary.BigEndian.PutUint16(resp[20:22], 0x0020)
+				binary.BigEndian.PutUint16(resp[22:24], 8)
+				resp[25] = 0x01
+				binary.BigEndian.PutUint16(resp[26:28], uint16(addr.Port)^0x2112)
+				ip := addr.IP.To4()
+				for i := range ip {
+					resp[28+i] = ip[i] ^ b[4+i]
+				}
+				conn.WriteToUDP(resp, addr)
+			}
+		}()
+		return conn
+	}
+
+	It("discovers the public address of a listener", func() {
+		server := runSTUNServer()
+		defer server.Close()
+		tr, err := NewTransport(key, WithSTUNServers(server.LocalAddr().String()))
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		addr, err := tr.(Transport).DiscoverPublicAddr(context.Background(), ln.Multiaddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(addr).To(Equal(ln.Multiaddr()))
+	})
+
+	It("errors if no STUN servers are configured", func() {
+		tr, err := NewTransport(key)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = tr.(Transport).DiscoverPublicAddr(context.Background(), ma.StringCast("/ip4/127.0.0.1/udp/1234/quic"))
+		Expect(err).To(MatchError(ErrNoSTUNServers))
+	})
+})
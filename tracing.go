@@ -0,0 +1,36 @@
+package libp2pquic
+
+import "context"
+
+// A Tracer creates the spans used for distributed tracing.
+// It can be implemented by a small adapter for OpenTelemetry, OpenCensus or OpenTracing.
+type Tracer interface {
+	// Start starts a span, as a child of the span contained in ctx, if any.
+	// The returned context contains the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a single operation within a trace.
+type Span interface {
+	// SetAttribute sets an attribute of the span.
+	SetAttribute(key, value string)
+	// RecordError records that the operation failed.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the span started by startTask, or nil if there's none.
+func spanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// traceError records the error in the span started by startTask, if any.
+func traceError(ctx context.Context, err error) {
+	if span := spanFromContext(ctx); span != nil && err != nil {
+		span.RecordError(err)
+	}
+}
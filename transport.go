@@ -7,9 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
 
@@ -19,106 +22,466 @@ import (
 	"github.com/whyrusleeping/mafmt"
 )
 
+// quicConfig is the default QUIC configuration.
+// It can be changed using WithQUICConfig, WithMaxStreams, WithMaxUniStreams, WithHandshakeTimeout,
+// WithIdleTimeout and WithKeepAlive.
+// Unless set, the AcceptToken callback is set by the transport, see WithAddressValidationThreshold.
 var quicConfig = &quic.Config{
 	MaxIncomingStreams:                    1000,
-	MaxIncomingUniStreams:                 -1,              // disable unidirectional streams
+	MaxIncomingUniStreams:                 -1,              // disable unidirectional streams, see WithMaxUniStreams
 	MaxReceiveStreamFlowControlWindow:     3 * (1 << 20),   // 3 MB
 	MaxReceiveConnectionFlowControlWindow: 4.5 * (1 << 20), // 4.5 MB
-	AcceptToken: func(clientAddr net.Addr, token *quic.Token) bool {
-		// TODO(#6): require source address validation when under load
-		return true
-	},
-	KeepAlive: true,
+	KeepAlive:                             true,
 }
 
+// ErrDialToSelf is returned when dialing our own peer ID.
+var ErrDialToSelf = errors.New("dial to self attempted")
+
+// ErrTransportClosed is returned when dialing after the transport was closed.
+var ErrTransportClosed = errors.New("transport closed")
+
 type connManager struct {
 	mutex sync.Mutex
 
+	config *config
+
+	closed   bool
 	connIPv4 net.PacketConn
 	connIPv6 net.PacketConn
+	// listenConns are the sockets used by listeners, and the network they listen on.
+	listenConns map[net.PacketConn]string
+	// nextSocket is the index of the next socket used by SelectRoundRobin
+	nextSocket uint64
+	// socketLoad returns the number of connections using a socket, for SelectLeastLoaded
+	socketLoad func(net.PacketConn) int
 }
 
-func (c *connManager) GetConnForAddr(network string) (net.PacketConn, error) {
+// GetConnForAddr returns the socket used to dial p at raddr.
+// If there are multiple eligible sockets, the SocketSelectionStrategy decides which one is used.
+func (c *connManager) GetConnForAddr(network string, raddr *net.UDPAddr, p peer.ID) (net.PacketConn, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	if c.closed {
+		return nil, ErrTransportClosed
+	}
+
+	dialAddr := c.config.dialAddr(network)
+	if !c.config.noListenSocketReuse && dialAddr == nil {
+		if conns := c.listenConnsForAddr(network, raddr); len(conns) > 0 {
+			return c.selectSocket(conns, p), nil
+		}
+	}
+
 	switch network {
 	case "udp4":
 		if c.connIPv4 != nil {
 			return c.connIPv4, nil
 		}
+		host := "0.0.0.0:0"
+		if dialAddr != nil {
+			host = dialAddr.String()
+		}
 		var err error
-		c.connIPv4, err = c.createConn(network, "0.0.0.0:0")
+		c.connIPv4, err = c.createConn(network, host)
 		return c.connIPv4, err
 	case "udp6":
 		if c.connIPv6 != nil {
 			return c.connIPv6, nil
 		}
+		host := ":0"
+		if dialAddr != nil {
+			host = dialAddr.String()
+		}
 		var err error
-		c.connIPv6, err = c.createConn(network, ":0")
+		c.connIPv6, err = c.createConn(network, host)
 		return c.connIPv6, err
 	default:
 		return nil, fmt.Errorf("unsupported network: %s", network)
 	}
 }
 
+// listenConnsForAddr returns the listening sockets that can be used to dial raddr.
+// Sockets listening on the unspecified address can be used to dial any address,
+// sockets listening on a loopback address can only be used to dial loopback addresses.
+// The sockets are sorted by their local address.
+func (c *connManager) listenConnsForAddr(network string, raddr *net.UDPAddr) []net.PacketConn {
+	var conns []net.PacketConn
+	for conn, lnet := range c.listenConns {
+		if lnet != network {
+			continue
+		}
+		laddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if laddr.IP.IsUnspecified() || (laddr.IP.IsLoopback() && raddr.IP.IsLoopback()) {
+			conns = append(conns, conn)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].LocalAddr().String() < conns[j].LocalAddr().String() })
+	return conns
+}
+
+// GetListenConnForAddr returns the socket of a listener that can be used to dial raddr.
+// It is used for hole punching, which requires dialing from the socket of a listener.
+// The coordinator learns the socket's address before dialing, so the SocketSelectionStrategy is not applied:
+// If there are multiple eligible sockets, the one with the lowest local address is used.
+func (c *connManager) GetListenConnForAddr(network string, raddr *net.UDPAddr) (net.PacketConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil, ErrTransportClosed
+	}
+	if conns := c.listenConnsForAddr(network, raddr); len(conns) > 0 {
+		return conns[0], nil
+	}
+	return nil, fmt.Errorf("hole punching to %s requires a listener", raddr)
+}
+
+// addListenConn registers the socket of a listener, so it can be reused for dialing.
+func (c *connManager) addListenConn(network string, conn net.PacketConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.listenConns == nil {
+		c.listenConns = make(map[net.PacketConn]string)
+	}
+	c.listenConns[conn] = network
+}
+
+// removeListenConn stops reusing the socket of a listener for new dials.
+func (c *connManager) removeListenConn(conn net.PacketConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.listenConns, conn)
+}
+
+// Close closes all sockets.
+// No new sockets are created afterwards.
+func (c *connManager) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	var err error
+	for _, conn := range []net.PacketConn{c.connIPv4, c.connIPv6} {
+		if conn == nil {
+			continue
+		}
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	c.connIPv4 = nil
+	c.connIPv6 = nil
+	return err
+}
+
 func (c *connManager) createConn(network, host string) (net.PacketConn, error) {
 	addr, err := net.ResolveUDPAddr(network, host)
 	if err != nil {
 		return nil, err
 	}
-	return net.ListenUDP(network, addr)
+	conn, err := c.config.listenUDP(network, addr)
+	if err != nil {
+		c.config.warnw("failed to create socket for dialing", "network", network, "addr", addr, "error", err)
+		return nil, err
+	}
+	c.config.debugw("created socket for dialing", "network", network, "addr", conn.LocalAddr())
+	return conn, nil
+}
+
+// Transport is a QUIC transport.
+// It extends the tpt.Transport with QUIC specific functionality.
+type Transport interface {
+	tpt.Transport
+
+	// SwapIdentity replaces the identity key of the transport.
+	// New connections, both dialed and accepted on listeners created after the swap, use the new identity.
+	// Existing connections and listeners keep their original identity until they are closed.
+	// Dials that are running concurrently with SwapIdentity may use either identity.
+	SwapIdentity(key ic.PrivKey) error
+	// SnapshotStats returns the statistics of all open connections.
+	// The statistics of each connection are a point-in-time snapshot.
+	// Snapshots of different connections are not taken atomically.
+	// Connections that are closed while taking the snapshot are omitted.
+	SnapshotStats() []ConnStatsSnapshot
+	// Probe checks if a peer is reachable at the given address, without returning a usable connection.
+	// The connection is closed as soon as the handshake and the verification of the peer ID completed.
+	Probe(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (ProbeResult, error)
+	// RotateCertificate generates a new certificate for the identity key.
+	// It is used for all new handshakes, both when dialing and on listeners using the current identity.
+	// Existing connections are not affected.
+	RotateCertificate() error
+	// DialMany dials the peer on all of the addresses, and returns the first connection established.
+	// IPv6 and IPv4 addresses are dialed alternately, starting with IPv6. Each dial gets a head start of 250ms
+	// before the next address is dialed, unless it fails earlier (Happy Eyeballs, RFC 8305).
+	// The dials that are still running when a connection is established are canceled.
+	DialMany(ctx context.Context, raddrs []ma.Multiaddr, p peer.ID) (tpt.CapableConn, error)
+	// ListenMany listens on all of the addresses, and returns a single listener accepting the connections of all of them.
+	// If listening on one of the addresses fails, the others are closed, and the error is returned.
+	ListenMany(addrs []ma.Multiaddr) (Listener, error)
+	// LocalAddrForDial returns the local address of the socket that a dial of raddr using ctx uses.
+	// This allows a hole punch coordinator to learn the port before dialing, see WithHolePunch.
+	// The socket is created if necessary. If it is bound to the unspecified address, so is the returned address.
+	// Hole punches always use the returned socket. Other dials might use a different one, depending on the
+	// SocketSelectionStrategy, see WithSocketSelectionStrategy.
+	LocalAddrForDial(ctx context.Context, raddr ma.Multiaddr) (ma.Multiaddr, error)
+	// WaitForPacket blocks until a packet from raddr is received on any socket of the transport, or ctx is done.
+	// Packets received before WaitForPacket was called are not taken into account.
+	// In a private network, only packets sent by members of the network are taken into account.
+	WaitForPacket(ctx context.Context, raddr ma.Multiaddr) error
+	// DiscoverPublicAddr discovers the public address of the socket bound to laddr, using the STUN servers
+	// configured with WithSTUNServers. Behind a NAT, this is the address that peers can reach the socket at,
+	// if the NAT maps the socket to the same address for all destinations.
+	// laddr is the address of a listener, or of a socket used for dialing, see LocalAddrForDial.
+	DiscoverPublicAddr(ctx context.Context, laddr ma.Multiaddr) (ma.Multiaddr, error)
+	// Close closes the UDP sockets used for dialing, which also closes all connections dialed from them.
+	// Connections dialed from the socket of a listener are closed when the listener's socket is closed.
+	// Dials fail with ErrTransportClosed afterwards.
+	// Listeners are not affected, they need to be closed separately.
+	Close() error
 }
 
 // The Transport implements the tpt.Transport interface for QUIC connections.
 type transport struct {
-	privKey     ic.PrivKey
-	localPeer   peer.ID
-	tlsConf     *tls.Config
+	mutex     sync.RWMutex // protects the identity (privKey, localPeer, tlsConf and specTLSConf), and the listeners
+	privKey   ic.PrivKey
+	localPeer peer.ID
+	tlsConf   *tls.Config
+	// specTLSConf is used for the handshake of the libp2p TLS specification. It is nil unless enabled.
+	specTLSConf *tls.Config
+	listeners   map[*listener]struct{}
+
 	connManager *connManager
+	config      *config
+	conns       connRegistry
+	holePunches holePunchRegistry
 }
 
-var _ tpt.Transport = &transport{}
+var _ Transport = &transport{}
 
 // NewTransport creates a new QUIC transport
-func NewTransport(key ic.PrivKey) (tpt.Transport, error) {
+func NewTransport(key ic.PrivKey, opts ...Option) (Transport, error) {
+	conf, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
 	localPeer, err := peer.IDFromPrivateKey(key)
 	if err != nil {
 		return nil, err
 	}
-	tlsConf, err := generateConfig(key)
+	tlsConf, specTLSConf, err := conf.generateTLSConfigs(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return &transport{
+	t := &transport{
 		privKey:     key,
 		localPeer:   localPeer,
 		tlsConf:     tlsConf,
-		connManager: &connManager{},
-	}, nil
+		specTLSConf: specTLSConf,
+		connManager: &connManager{config: conf},
+		config:      conf,
+		conns:       connRegistry{duplicatePolicy: conf.duplicatePolicy},
+	}
+	t.connManager.socketLoad = t.socketLoad
+	return t, nil
+}
+
+// SwapIdentity replaces the identity key of the transport.
+func (t *transport) SwapIdentity(key ic.PrivKey) error {
+	localPeer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	tlsConf, specTLSConf, err := t.config.generateTLSConfigs(key)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	t.privKey = key
+	t.localPeer = localPeer
+	t.tlsConf = tlsConf
+	t.specTLSConf = specTLSConf
+	t.mutex.Unlock()
+	return nil
+}
+
+// RotateCertificate generates a new certificate for the identity key.
+func (t *transport) RotateCertificate() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tlsConf, specTLSConf, err := t.config.generateTLSConfigs(t.privKey)
+	if err != nil {
+		return err
+	}
+	t.tlsConf = tlsConf
+	t.specTLSConf = specTLSConf
+	for l := range t.listeners {
+		// listeners created before SwapIdentity keep using the old identity
+		if l.privKey.Equals(t.privKey) {
+			l.setTLSConfig(tlsConf, specTLSConf)
+		}
+	}
+	return nil
+}
+
+func (t *transport) addListener(l *listener) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.listeners == nil {
+		t.listeners = make(map[*listener]struct{})
+	}
+	t.listeners[l] = struct{}{}
+}
+
+func (t *transport) removeListener(l *listener) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.listeners, l)
+}
+
+func (t *transport) identity() (ic.PrivKey, peer.ID, *tls.Config, *tls.Config) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.privKey, t.localPeer, t.tlsConf, t.specTLSConf
 }
 
 // Dial dials a new QUIC connection
+// DNS multiaddrs (/dns4, /dns6 and /dnsaddr) are resolved first. If they resolve to multiple addresses,
+// these are dialed like in DialMany.
+// If connection reuse is enabled, an open connection established by an earlier dial may be returned, see WithConnectionReuse.
 func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
-	network, host, err := manet.DialArgs(raddr)
+	return t.dialCached(ctx, []ma.Multiaddr{raddr}, p)
+}
+
+func (t *transport) dialAndReport(ctx context.Context, raddrs []ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	ctx, endTask := t.config.startTask(ctx, "quic dial")
+	defer endTask()
+	t.config.tracePeer(ctx, p)
+	var timings handshakeTimer
+	timings.start = time.Now()
+	c, err := t.dialResolved(ctx, raddrs, p, &timings)
+	traceError(ctx, err)
+	// When dialing multiple addresses, the address of the connection is recorded.
+	raddr := raddrs[0]
+	if len(raddrs) > 1 && c != nil {
+		raddr = c.remoteMultiaddr
+	}
+	if t.config.handshakeRecorder != nil {
+		r := newHandshakeRecord(network.DirOutbound, raddr, p, timings.start, c, err)
+		r.Timings = timings.timings()
+		t.config.handshakeRecorder(r)
+	}
+	if t.config.metrics != nil {
+		t.config.metrics.dialed(timings.timings().Total, err)
+	}
 	if err != nil {
+		if len(raddrs) > 1 {
+			t.config.debugw("dial failed", "peer", p, "addrs", raddrs, "error", err)
+		} else {
+			t.config.debugw("dial failed", "peer", p, "addr", raddr, "error", err)
+		}
 		return nil, err
 	}
-	pconn, err := t.connManager.GetConnForAddr(network)
+	t.config.debugw("dialed connection", "peer", p, "addr", raddr, "local_addr", c.localMultiaddr)
+	if t.config.metrics != nil {
+		t.config.metrics.connOpened(c)
+	}
+	t.config.reportObservedAddrs(c)
+	if t.config.onConnected != nil {
+		t.config.onConnected(c)
+	}
+	return c, nil
+}
+
+// dialResolved resolves DNS multiaddrs, and dials the resolved addresses using Happy Eyeballs.
+// Addresses that fail to resolve are skipped.
+func (t *transport) dialResolved(ctx context.Context, raddrs []ma.Multiaddr, p peer.ID, timings *handshakeTimer) (*conn, error) {
+	addrs := make([]ma.Multiaddr, 0, len(raddrs))
+	var resolveErr error
+	for _, raddr := range raddrs {
+		if !isDNSMultiaddr(raddr) {
+			addrs = append(addrs, raddr)
+			continue
+		}
+		endRegion := t.config.startRegion(ctx, "resolve DNS")
+		resolved, err := resolve(ctx, raddr, p)
+		endRegion()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			resolveErr = err
+			continue
+		}
+		addrs = append(addrs, resolved...)
+	}
+	if len(addrs) == 0 {
+		return nil, resolveErr
+	}
+	return t.dialHappyEyeballs(ctx, addrs, p, timings)
+}
+
+func (t *transport) dialAddr(ctx context.Context, raddr ma.Multiaddr, p peer.ID, timings *handshakeTimer) (*conn, error) {
+	if isHolePunch, isClient := holePunchFromContext(ctx); isHolePunch && !isClient {
+		return t.holePunch(ctx, raddr, p, timings)
+	}
+	return t.dial(ctx, raddr, p, timings)
+}
+
+func (t *transport) dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID, timings *handshakeTimer) (*conn, error) {
+	privKey, localPeer, tlsConf, specTLSConf := t.identity()
+	getPubKey := getRemotePubKey
+	if specTLSConf != nil {
+		tlsConf = specTLSConf
+		getPubKey = getSpecRemotePubKey
+	}
+	// No matter which address we're dialing, only we can prove possession of our private key.
+	// We therefore don't need to check the address, dialing our own peer ID is always a dial to ourselves.
+	if p == localPeer {
+		return nil, ErrDialToSelf
+	}
+	if t.config.gater != nil && !t.config.gater.InterceptAddrDial(p, raddr) {
+		return nil, ErrGated
+	}
+	endRegion := t.config.startRegion(ctx, "resolve address")
+	netw, host, udpAddr, err := resolveUDPAddr(raddr)
+	endRegion()
 	if err != nil {
 		return nil, err
 	}
-	addr, err := fromQuicMultiaddr(raddr)
+	endRegion = t.config.startRegion(ctx, "acquire socket")
+	pconn, err := t.acquireConn(ctx, netw, udpAddr, p)
+	endRegion()
 	if err != nil {
+		return nil, newDialError(raddr, p, err, nil)
+	}
+	timings.socketAcquired = time.Now()
+	if err := waitForDialTime(ctx); err != nil {
 		return nil, err
 	}
 	var remotePubKey ic.PubKey
-	tlsConf := t.tlsConf.Clone()
+	// verifyErr is the error returned by VerifyPeerCertificate, if any
+	var verifyErr error
+	tlsConf = tlsConf.Clone()
+	if t.config.clientSessionCache != nil {
+		tlsConf.ClientSessionCache = &peerSessionCache{cache: t.config.clientSessionCache, peer: p}
+	}
+	overrideTLSConfig(ctx, tlsConf)
 	// We need to check the peer ID in the VerifyPeerCertificate callback.
 	// The tls.Config it is also used for listening, and we might also have concurrent dials.
 	// Clone it so we can check for the specific peer ID we're dialing here.
-	tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) (err error) {
+		defer func() { verifyErr = err }()
+		timings.serverFlightReceived = time.Now()
 		chain := make([]*x509.Certificate, len(rawCerts))
 		for i := 0; i < len(rawCerts); i++ {
 			cert, err := x509.ParseCertificate(rawCerts[i])
@@ -127,44 +490,118 @@ func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tp
 			}
 			chain[i] = cert
 		}
-		var err error
-		remotePubKey, err = getRemotePubKey(chain)
+		remotePubKey, err = getPubKey(chain, t.config.ignoreCertTimeValidity)
 		if err != nil {
 			return err
 		}
 		if !p.MatchesPublicKey(remotePubKey) {
-			return errors.New("peer IDs don't match")
+			return ErrPeerIDMismatch
 		}
 		return nil
 	}
-	sess, err := quic.DialContext(ctx, pconn, addr, host, tlsConf, quicConfig)
+	endRegion = t.config.startRegion(ctx, "handshake")
+	sess, err := quic.DialContext(ctx, pconn, udpAddr, host, tlsConf, t.config.dialQUICConfig(ctx))
+	endRegion()
+	timings.done = time.Now()
 	if err != nil {
-		return nil, err
+		return nil, newDialError(raddr, p, err, verifyErr)
+	}
+	// When resuming a session, the server doesn't send its certificate, so VerifyPeerCertificate isn't called.
+	// The certificate chain is restored from the session.
+	if remotePubKey == nil {
+		remotePubKey, err = getPubKey(sess.ConnectionState().PeerCertificates, t.config.ignoreCertTimeValidity)
+		if err != nil {
+			sess.CloseWithError(0, err.Error())
+			return nil, err
+		}
+		if !p.MatchesPublicKey(remotePubKey) {
+			sess.CloseWithError(0, ErrPeerIDMismatch.Error())
+			return nil, newDialError(raddr, p, ErrPeerIDMismatch, nil)
+		}
 	}
 	localMultiaddr, err := toQuicMultiaddr(sess.LocalAddr())
 	if err != nil {
 		return nil, err
 	}
-	return &conn{
+	closeCtx, closeCancel := context.WithCancel(sess.Context())
+	c := &conn{
 		sess:            sess,
 		transport:       t,
-		privKey:         t.privKey,
-		localPeer:       t.localPeer,
+		closeCtx:        closeCtx,
+		closeCancel:     closeCancel,
+		privKey:         privKey,
+		localPeer:       localPeer,
 		localMultiaddr:  localMultiaddr,
 		remotePubKey:    remotePubKey,
 		remotePeerID:    p,
 		remoteMultiaddr: raddr,
-	}, nil
+		direction:       network.DirOutbound,
+		version:         t.config.version(),
+		opened:          time.Now(),
+		timings:         timings.timings(),
+	}
+	if t.config.gater != nil && !t.config.gater.InterceptSecured(network.DirOutbound, p, c) {
+		sess.CloseWithError(ErrorCodeGated, ErrGated.Error())
+		return nil, ErrGated
+	}
+	if err := c.openScope(t.config.resourceManager, t.config.quicConfig); err != nil {
+		sess.CloseWithError(ErrorCodeResourceLimitExceeded, err.Error())
+		return nil, err
+	}
+	if err := t.conns.add(c); err != nil {
+		return nil, err
+	}
+	if t.config.maxConnLifetime > 0 {
+		c.closeAfterLifetime(t.config.maxConnLifetime)
+	}
+	return c, nil
+}
+
+// resolveUDPAddr returns the network, the host and the UDP address of a QUIC multiaddr.
+func resolveUDPAddr(raddr ma.Multiaddr) (string, string, *net.UDPAddr, error) {
+	netw, host, err := manet.DialArgs(raddr)
+	if err != nil {
+		return "", "", nil, err
+	}
+	addr, err := fromQuicMultiaddr(raddr)
+	if err != nil {
+		return "", "", nil, err
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return "", "", nil, fmt.Errorf("not a UDP address: %s", addr)
+	}
+	return netw, host, udpAddr, nil
+}
+
+// Close closes the transport.
+func (t *transport) Close() error {
+	return t.connManager.Close()
 }
 
 // CanDial determines if we can dial to an address
 func (t *transport) CanDial(addr ma.Multiaddr) bool {
-	return mafmt.QUIC.Matches(addr)
+	ok := mafmt.QUIC.Matches(addr) || isDNSMultiaddr(addr)
+	if t.config.canDialTrace {
+		if ok {
+			t.config.debugw("accepted address for dialing", "addr", addr)
+		} else {
+			t.config.debugw("rejected address for dialing", "addr", addr, "reason", "not a QUIC multiaddr")
+		}
+	}
+	return ok
 }
 
 // Listen listens for new QUIC connections on the passed multiaddr.
 func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
-	return newListener(addr, t, t.localPeer, t.privKey, t.tlsConf)
+	privKey, localPeer, tlsConf, specTLSConf := t.identity()
+	ln, err := newListener(addr, t, localPeer, privKey, tlsConf, specTLSConf, t.config)
+	if err != nil {
+		t.config.warnw("listen failed", "addr", addr, "error", err)
+		return nil, err
+	}
+	t.config.infow("listening", "addr", ln.Multiaddr())
+	return ln, nil
 }
 
 // Proxy returns true if this transport proxies.
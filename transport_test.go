@@ -1,7 +1,17 @@
 package libp2pquic
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	ic "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/pnet"
 	tpt "github.com/libp2p/go-libp2p-core/transport"
+	quic "github.com/lucas-clemente/quic-go"
 	ma "github.com/multiformats/go-multiaddr"
 
 	. "github.com/onsi/ginkgo"
@@ -12,7 +22,7 @@ var _ = Describe("Transport", func() {
 	var t tpt.Transport
 
 	BeforeEach(func() {
-		t = &transport{}
+		t = &transport{config: &config{}}
 	})
 
 	It("says if it can dial an address", func() {
@@ -29,4 +39,89 @@ var _ = Describe("Transport", func() {
 		Expect(protocols).To(HaveLen(1))
 		Expect(protocols[0]).To(Equal(ma.P_QUIC))
 	})
+
+	It("logs the decisions made by CanDial, if enabled", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		logger := &recordingLogger{}
+		tr, err := NewTransport(key, WithLogger(logger), WithCanDialTrace())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tr.CanDial(ma.StringCast("/ip4/127.0.0.1/tcp/1234"))).To(BeFalse())
+		Expect(tr.CanDial(ma.StringCast("/ip4/127.0.0.1/udp/1234/quic"))).To(BeTrue())
+		Expect(logger.Messages()).To(Equal([]string{
+			"rejected address for dialing addr=/ip4/127.0.0.1/tcp/1234 reason=not a QUIC multiaddr",
+			"accepted address for dialing addr=/ip4/127.0.0.1/udp/1234/quic",
+		}))
+	})
+
+	It("only allows QUIC versions supported by quic-go", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewTransport(key, WithVersions(VersionDraft22, 0x6b3343cf)) // QUIC v2
+		Expect(err).To(MatchError("QUIC version 0x6b3343cf is not supported"))
+	})
+
+	It("copies the QUIC versions", func() {
+		versions := []quic.VersionNumber{VersionDraft22}
+		conf, err := newConfig(WithVersions(versions...))
+		Expect(err).ToNot(HaveOccurred())
+		versions[0] = 0x6b3343cf
+		Expect(conf.quicConfig.Versions).To(Equal([]quic.VersionNumber{VersionDraft22}))
+	})
+
+	It("requires the high watermark of inbound connections to be below the maximum", func() {
+		_, err := newConfig(WithMaxInboundConns(10, 11))
+		Expect(err).To(MatchError("high watermark must be positive, and not larger than the maximum number of inbound connections"))
+		conf, err := newConfig(WithMaxInboundConns(10, 10))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.maxInboundConns).To(Equal(10))
+	})
+
+	It("only allows TLS key logging if enabled by the environment", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewTransport(key, WithKeyLogWriter(ioutil.Discard))
+		Expect(err).To(MatchError("TLS key logging requires the environment variable LIBP2P_QUIC_ALLOW_KEY_LOG=1"))
+	})
+
+	It("requires a private network, if enforced by the environment", func() {
+		pnet.ForcePrivateNetwork = true
+		defer func() { pnet.ForcePrivateNetwork = false }()
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		key, err := ic.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewTransport(key)
+		Expect(err).To(MatchError(pnet.ErrNotInPrivateNetwork))
+		_, err = NewTransport(key, WithPrivateNetwork(make([]byte, 32)))
+		Expect(err).ToNot(HaveOccurred())
+	})
 })
+
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) log(format string, args ...interface{}) {
+	l.mutex.Lock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+	l.mutex.Unlock()
+}
+
+func (l *recordingLogger) Messages() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.messages
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) { l.log(format, args...) }
+func (l *recordingLogger) Infof(format string, args ...interface{})  { l.log(format, args...) }
+func (l *recordingLogger) Warnf(format string, args ...interface{})  { l.log(format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.log(format, args...) }
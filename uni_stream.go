@@ -0,0 +1,129 @@
+package libp2pquic
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/network"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// A SendStream is the sending side of a unidirectional stream.
+type SendStream interface {
+	io.WriteCloser
+	// Reset aborts sending on the stream. Data that wasn't delivered yet might be lost.
+	Reset() error
+}
+
+// A ReceiveStream is the receiving side of a unidirectional stream.
+type ReceiveStream interface {
+	io.Reader
+	// Reset tells the peer to stop sending on the stream.
+	Reset() error
+}
+
+type sendStream struct {
+	quic.SendStream
+
+	conn *conn
+	// scope accounts for the resources of the stream. It is nil if no ResourceManager is configured.
+	scope StreamScope
+	done  uint32
+}
+
+var _ SendStream = &sendStream{}
+
+func (s *sendStream) Write(b []byte) (int, error) {
+	n, err := s.SendStream.Write(b)
+	atomic.AddUint64(&s.conn.stats.bytesSent, uint64(n))
+	if isCanceled(err) {
+		s.closed()
+	}
+	return n, err
+}
+
+func (s *sendStream) Close() error {
+	s.closed()
+	return s.SendStream.Close()
+}
+
+func (s *sendStream) Reset() error {
+	s.SendStream.CancelWrite(0)
+	s.closed()
+	return nil
+}
+
+func (s *sendStream) closed() {
+	if atomic.CompareAndSwapUint32(&s.done, 0, 1) && s.scope != nil {
+		s.scope.Done()
+	}
+}
+
+type receiveStream struct {
+	quic.ReceiveStream
+
+	conn *conn
+	// scope accounts for the resources of the stream. It is nil if no ResourceManager is configured.
+	scope StreamScope
+	done  uint32
+}
+
+var _ ReceiveStream = &receiveStream{}
+
+func (s *receiveStream) Read(b []byte) (int, error) {
+	n, err := s.ReceiveStream.Read(b)
+	atomic.AddUint64(&s.conn.stats.bytesReceived, uint64(n))
+	if err == io.EOF || isCanceled(err) {
+		s.closed()
+	}
+	return n, err
+}
+
+func (s *receiveStream) Reset() error {
+	s.ReceiveStream.CancelRead(0)
+	s.closed()
+	return nil
+}
+
+func (s *receiveStream) closed() {
+	if atomic.CompareAndSwapUint32(&s.done, 0, 1) && s.scope != nil {
+		s.scope.Done()
+	}
+}
+
+// OpenUniStream opens a new unidirectional stream.
+func (c *conn) OpenUniStream(ctx context.Context) (SendStream, error) {
+	scope, err := c.openStreamScope(network.DirOutbound)
+	if err != nil {
+		return nil, err
+	}
+	qstr, err := c.sess.OpenUniStreamSync(ctx)
+	if err != nil {
+		if scope != nil {
+			scope.Done()
+		}
+		return nil, c.closeError(err)
+	}
+	atomic.AddUint64(&c.stats.streamsOpened, 1)
+	return &sendStream{SendStream: qstr, conn: c, scope: scope}, nil
+}
+
+// AcceptUniStream accepts a unidirectional stream opened by the other side.
+func (c *conn) AcceptUniStream(ctx context.Context) (ReceiveStream, error) {
+	for {
+		qstr, err := c.sess.AcceptUniStream(ctx)
+		if err != nil {
+			return nil, c.closeError(err)
+		}
+		scope, err := c.openStreamScope(network.DirInbound)
+		if err != nil {
+			// stop the stream, and accept the next one
+			qstr.CancelRead(0)
+			continue
+		}
+		atomic.AddUint64(&c.stats.streamsAccepted, 1)
+		return &receiveStream{ReceiveStream: qstr, conn: c, scope: scope}, nil
+	}
+}
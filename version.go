@@ -0,0 +1,31 @@
+package libp2pquic
+
+import quic "github.com/lucas-clemente/quic-go"
+
+// VersionDraft22 is the QUIC version of draft-22 of the IETF QUIC specification.
+// It is the only version supported by quic-go v0.12.
+// QUIC v1 (RFC 9000) and QUIC v2 (RFC 9369) require a newer quic-go version.
+const VersionDraft22 quic.VersionNumber = 0xff000016
+
+// supportedVersions are the QUIC versions supported by quic-go, in order of preference.
+var supportedVersions = []quic.VersionNumber{VersionDraft22}
+
+func isSupportedVersion(v quic.VersionNumber) bool {
+	for _, s := range supportedVersions {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// version returns the QUIC version used by connections.
+// Clients start the handshake with the most preferred version, and servers only accept the configured versions.
+// quic-go v0.12 doesn't expose the version of a session, but as it only supports a single version,
+// the first configured version is the negotiated one.
+func (c *config) version() quic.VersionNumber {
+	if len(c.quicConfig.Versions) > 0 {
+		return c.quicConfig.Versions[0]
+	}
+	return supportedVersions[0]
+}